@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const ApiKeyHeader = "X-Api-Key"
+
+// 静态API Key认证
+type apiKeyAuth struct {
+	keys []*cfg.ApiKey
+}
+
+func newApiKeyAuth(keys []*cfg.ApiKey) *apiKeyAuth {
+	return &apiKeyAuth{keys: keys}
+}
+
+func (a *apiKeyAuth) Authenticate(r *http.Request, body []byte) (*Identity, error) {
+	key := r.Header.Get(ApiKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	for _, k := range a.keys {
+		if k.Key != "" && subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return &Identity{Client: k.Client, Method: "apikey", Scopes: k.Scopes}, nil
+		}
+	}
+	return nil, ErrUnauthorized
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+// 管理接口的权限
+const ScopeAdmin = "admin"
+
+// 示例配置中的API Key,启用时拒绝启动
+const PlaceholderKey = "change-me"
+
+var (
+	// 请求中没有当前认证方式的凭据,尝试下一种认证方式
+	ErrNoCredentials = errors.New("缺少认证信息")
+	ErrUnauthorized  = errors.New("认证失败")
+)
+
+// 通过认证的调用方
+type Identity struct {
+	Client string   //调用方名称
+	Method string   //认证方式
+	Scopes []string //权限
+}
+
+// 是否允许调用任务,name为任务的完整名称
+func (i *Identity) Allows(name string) bool {
+	return Match(i.Scopes, name)
+}
+
+// 任务名称是否匹配权限,*为全部任务,site/*为站点中的全部任务
+func Match(scopes []string, name string) bool {
+	for _, scope := range scopes {
+		if scope == "*" || scope == name {
+			return true
+		}
+		if strings.HasSuffix(scope, cfg.SiteSeparator+"*") && strings.HasPrefix(name, scope[:len(scope)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// 任务名称是否被明确列出,不使用通配符
+func MatchExact(scopes []string, name string) bool {
+	for _, scope := range scopes {
+		if scope == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否允许访问管理接口
+func (i *Identity) IsAdmin() bool {
+	for _, scope := range i.Scopes {
+		if scope == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// 认证方式
+type Authenticator interface {
+	// 请求中没有该方式的凭据时返回ErrNoCredentials
+	Authenticate(r *http.Request, body []byte) (*Identity, error)
+}
+
+// 按顺序尝试多种认证方式
+type Chain []Authenticator
+
+// 按配置创建认证方式
+func New(c *cfg.Auth) (Chain, error) {
+	if c == nil {
+		return nil, nil
+	}
+	// 其他认证方式的调用方名称,JWT令牌不能使用
+	reserved := make(map[string]bool)
+	var keys []*cfg.ApiKey
+	for _, k := range c.ApiKeys {
+		if k.Client == "" {
+			return nil, errors.New("API Key必须设置调用方名称")
+		}
+		reserved[k.Client] = true
+		if k.Disabled {
+			continue
+		}
+		if k.Key == PlaceholderKey {
+			return nil, fmt.Errorf("调用方[%s]的API Key仍为示例值%s,请修改后再启动", k.Client, PlaceholderKey)
+		}
+		keys = append(keys, k)
+	}
+	if c.Hmac != nil {
+		for _, client := range c.Hmac.Clients {
+			if client.Client == "" {
+				return nil, errors.New("HMAC调用方名称不能为空")
+			}
+			reserved[client.Client] = true
+		}
+	}
+	var chain Chain
+	if len(keys) > 0 {
+		chain = append(chain, newApiKeyAuth(keys))
+	}
+	if c.Hmac != nil {
+		chain = append(chain, newHmacAuth(c.Hmac))
+	}
+	if c.Jwt != nil {
+		jwtAuth, err := newJwtAuth(c.Jwt, reserved)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, jwtAuth)
+	}
+	return chain, nil
+}
+
+func (c Chain) Authenticate(r *http.Request, body []byte) (*Identity, error) {
+	for _, a := range c {
+		identity, err := a.Authenticate(r, body)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return identity, err
+	}
+	return nil, ErrNoCredentials
+}
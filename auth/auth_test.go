@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		want     bool
+	}{
+		{[]string{"*"}, "list", true},
+		{[]string{"*"}, "shop/list", true},
+		{[]string{"shop/*"}, "shop/list", true},
+		{[]string{"shop/*"}, "list", false},
+		{[]string{"list"}, "list", true},
+		{[]string{"list"}, "detail", false},
+		{nil, "list", false},
+	}
+	for _, test := range tests {
+		if got := Match(test.patterns, test.name); got != test.want {
+			t.Errorf("Match(%v, %s) = %v, want %v", test.patterns, test.name, got, test.want)
+		}
+	}
+}
+
+func TestApiKey(t *testing.T) {
+	chain, err := New(&cfg.Auth{ApiKeys: []*cfg.ApiKey{{Client: "demo", Key: "secret", Scopes: []string{"*"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key    string
+		client string
+		err    error
+	}{
+		{"secret", "demo", nil},
+		{"wrong", "", ErrUnauthorized},
+		{"", "", ErrNoCredentials},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/call", nil)
+		if test.key != "" {
+			r.Header.Set(ApiKeyHeader, test.key)
+		}
+		identity, err := chain.Authenticate(r, nil)
+		if err != test.err {
+			t.Errorf("key %q: err = %v, want %v", test.key, err, test.err)
+			continue
+		}
+		if identity != nil && identity.Client != test.client {
+			t.Errorf("key %q: client = %s, want %s", test.key, identity.Client, test.client)
+		}
+	}
+}
+
+func TestNewRejectsEmptyClient(t *testing.T) {
+	configs := []*cfg.Auth{
+		{ApiKeys: []*cfg.ApiKey{{Key: "secret"}}},
+		{Hmac: &cfg.HmacAuth{Clients: []*cfg.HmacClient{{Secret: "secret"}}}},
+		{ApiKeys: []*cfg.ApiKey{{Client: "demo", Key: PlaceholderKey}}},
+	}
+	for i, c := range configs {
+		if _, err := New(c); err == nil {
+			t.Errorf("config %d: expected error", i)
+		}
+	}
+}
+
+func TestHmac(t *testing.T) {
+	chain, err := New(&cfg.Auth{Hmac: &cfg.HmacAuth{Clients: []*cfg.HmacClient{{Client: "demo", Secret: "secret"}}, MaxSkew: 60}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"Task":"list"}`)
+	now := time.Now().Unix()
+	tests := []struct {
+		name      string
+		client    string
+		secret    string
+		timestamp int64
+		nonce     string
+		body      []byte
+		query     string //签名时使用的查询参数
+		target    string //请求地址
+		err       error
+	}{
+		{"valid", "demo", "secret", now, "n1", body, "", "/call", nil},
+		{"replayed nonce", "demo", "secret", now, "n1", body, "", "/call", ErrUnauthorized},
+		{"wrong secret", "demo", "other", now, "n2", body, "", "/call", ErrUnauthorized},
+		{"unknown client", "other", "secret", now, "n3", body, "", "/call", ErrUnauthorized},
+		{"expired timestamp", "demo", "secret", now - 120, "n4", body, "", "/call", ErrUnauthorized},
+		{"future timestamp", "demo", "secret", now + 120, "n5", body, "", "/call", ErrUnauthorized},
+		{"missing nonce", "demo", "secret", now, "", body, "", "/call", ErrUnauthorized},
+		{"tampered body", "demo", "secret", now, "n6", []byte(`{"Task":"login"}`), "", "/call", ErrUnauthorized},
+		{"query", "demo", "secret", now, "n7", body, "id=1&b=2&b=1", "/call?b=1&id=1&b=2", nil},
+		{"unsigned query", "demo", "secret", now, "n8", body, "", "/call?id=1", ErrUnauthorized},
+		{"tampered query", "demo", "secret", now, "n9", body, "id=1", "/call?id=2", ErrUnauthorized},
+	}
+	for _, test := range tests {
+		timestamp := strconv.FormatInt(test.timestamp, 10)
+		r := httptest.NewRequest(http.MethodPost, test.target, bytes.NewReader(test.body))
+		r.Header.Set(ClientHeader, test.client)
+		r.Header.Set(TimestampHeader, timestamp)
+		r.Header.Set(NonceHeader, test.nonce)
+		r.Header.Set(SignatureHeader, Sign(test.secret, http.MethodPost, "/call", test.query, timestamp, test.nonce, body))
+		identity, err := chain.Authenticate(r, test.body)
+		if err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && identity.Client != "demo" {
+			t.Errorf("%s: client = %s", test.name, identity.Client)
+		}
+	}
+}
+
+func TestJwt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+	}})
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(file, jwks, 0644); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := New(&cfg.Auth{
+		ApiKeys: []*cfg.ApiKey{{Client: "keyed", Key: "secret"}},
+		Jwt:     &cfg.JwtAuth{JwksFile: file, Issuer: "issuer", Audience: "agent"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(extra map[string]interface{}) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "demo", "iss": "issuer", "aud": "agent", "exp": exp, "scope": "list detail"}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, c jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, c)
+		token.Header["kid"] = kid
+		str, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return str
+	}
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"rsa", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), nil},
+		{"ec", sign(jwt.SigningMethodES256, "ec", ecKey, claims(nil)), nil},
+		{"expired", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})), ErrUnauthorized},
+		{"missing exp", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]interface{}{"exp": nil})), ErrUnauthorized},
+		{"missing client", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]interface{}{"sub": nil})), ErrUnauthorized},
+		{"empty client", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]interface{}{"sub": ""})), ErrUnauthorized},
+		{"api key client", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]interface{}{"sub": "keyed"})), ErrUnauthorized},
+		{"wrong issuer", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]interface{}{"iss": "other"})), ErrUnauthorized},
+		{"wrong audience", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"})), ErrUnauthorized},
+		{"unknown kid", sign(jwt.SigningMethodRS256, "other", rsaKey, claims(nil)), ErrUnauthorized},
+		{"wrong key", sign(jwt.SigningMethodRS256, "rsa", otherKey, claims(nil)), ErrUnauthorized},
+		{"hmac algorithm", sign(jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(nil)), ErrUnauthorized},
+		{"malformed", "not.a.token", ErrUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/call", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		identity, err := chain.Authenticate(r, nil)
+		if err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && (identity.Client != "demo" || !identity.Allows("detail") || identity.Allows("login")) {
+			t.Errorf("%s: identity = %+v", test.name, identity)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/call", nil)
+	if _, err := chain.Authenticate(r, nil); err != ErrNoCredentials {
+		t.Errorf("no token: err = %v, want %v", err, ErrNoCredentials)
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const (
+	ClientHeader    = "X-Client"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+	SignatureHeader = "X-Signature"
+
+	defaultMaxSkew = 300 * time.Second
+)
+
+// HMAC签名认证
+// 签名为 hex(HMAC-SHA256(secret, 方法\n路径\n查询参数\n时间戳\n随机数\nhex(SHA256(请求体)))),时间戳为Unix秒
+// 查询参数按键和值排序后重新编码,如 a=1&b=2&b=3,没有查询参数时为空字符串
+// 允许的时间偏差内相同调用方的随机数只能使用一次
+type hmacAuth struct {
+	clients map[string]*cfg.HmacClient
+	maxSkew time.Duration
+	lock    sync.Mutex
+	nonces  map[string]time.Time
+	cleaned time.Time
+}
+
+func newHmacAuth(c *cfg.HmacAuth) *hmacAuth {
+	a := &hmacAuth{clients: make(map[string]*cfg.HmacClient, len(c.Clients)), maxSkew: defaultMaxSkew, nonces: make(map[string]time.Time)}
+	if c.MaxSkew > 0 {
+		a.maxSkew = time.Duration(c.MaxSkew) * time.Second
+	}
+	for _, client := range c.Clients {
+		a.clients[client.Client] = client
+	}
+	return a
+}
+
+func (a *hmacAuth) Authenticate(r *http.Request, body []byte) (*Identity, error) {
+	signature := r.Header.Get(SignatureHeader)
+	if signature == "" {
+		return nil, ErrNoCredentials
+	}
+	client, ok := a.clients[r.Header.Get(ClientHeader)]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		return nil, ErrUnauthorized
+	}
+	if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+		return nil, ErrUnauthorized
+	}
+	now := time.Now()
+	signed := time.Unix(seconds, 0)
+	if signed.Before(now.Add(-a.maxSkew)) || signed.After(now.Add(a.maxSkew)) {
+		return nil, ErrUnauthorized
+	}
+	expected := Sign(client.Secret, r.Method, r.URL.Path, r.URL.RawQuery, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return nil, ErrUnauthorized
+	}
+	if !a.useNonce(client.Client+"\n"+nonce, now) {
+		return nil, ErrUnauthorized
+	}
+	return &Identity{Client: client.Client, Method: "hmac", Scopes: client.Scopes}, nil
+}
+
+// 记录随机数,已使用过时返回false,过期的随机数每秒最多清理一次
+func (a *hmacAuth) useNonce(key string, now time.Time) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if now.Sub(a.cleaned) > time.Second {
+		for k, expire := range a.nonces {
+			if now.After(expire) {
+				delete(a.nonces, k)
+			}
+		}
+		a.cleaned = now
+	}
+	if expire, ok := a.nonces[key]; ok && now.Before(expire) {
+		return false
+	}
+	if _, ok := a.nonces[key]; ok {
+		return false
+	}
+	// 时间戳可以比当前时间晚maxSkew,随机数需要保留到该请求过期
+	a.nonces[key] = now.Add(2 * a.maxSkew)
+	return true
+}
+
+// 计算请求签名,rawQuery为未解码的查询字符串
+func Sign(secret string, method string, path string, rawQuery string, timestamp string, nonce string, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{strings.ToUpper(method), path, canonicalQuery(rawQuery), timestamp, nonce, hex.EncodeToString(bodySum[:])}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 查询参数按键和值排序后重新编码,参数的顺序和编码方式不影响签名
+func canonicalQuery(rawQuery string) string {
+	values, _ := url.ParseQuery(rawQuery)
+	for _, v := range values {
+		sort.Strings(v)
+	}
+	return values.Encode()
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const (
+	defaultClientClaim = "sub"
+	defaultScopeClaim  = "scope"
+)
+
+// JWKS中的密钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWT认证,使用本地JWKS文件中的RSA或EC公钥校验签名
+// reserved为API Key和HMAC的调用方名称,令牌不能冒用这些调用方的会话、配额和异步任务
+type jwtAuth struct {
+	config   *cfg.JwtAuth
+	keys     map[string]interface{}
+	parser   *jwt.Parser
+	reserved map[string]bool
+}
+
+func newJwtAuth(c *cfg.JwtAuth, reserved map[string]bool) (*jwtAuth, error) {
+	path := c.JwksFile
+	if !filepath.IsAbs(path) {
+		appPath, _ := cfg.AppPath()
+		path = filepath.Join(appPath, "config", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取JWKS文件发生错误:%v", err)
+	}
+	keys, err := parseJwks(data)
+	if err != nil {
+		return nil, err
+	}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}))
+	return &jwtAuth{config: c, keys: keys, parser: parser, reserved: reserved}, nil
+}
+
+func parseJwks(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("JWKS格式错误:%v", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS密钥[%s]错误:%v", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS中没有密钥")
+	}
+	return keys, nil
+}
+
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线:%s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型:%s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (a *jwtAuth) Authenticate(r *http.Request, body []byte) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(strings.TrimSpace(header[len("Bearer "):]), claims, a.keyFunc)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	if a.config.Issuer != "" && !claims.VerifyIssuer(a.config.Issuer, true) {
+		return nil, ErrUnauthorized
+	}
+	if a.config.Audience != "" && !claims.VerifyAudience(a.config.Audience, true) {
+		return nil, ErrUnauthorized
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, ErrUnauthorized
+	}
+	clientClaim := a.config.ClientClaim
+	if clientClaim == "" {
+		clientClaim = defaultClientClaim
+	}
+	scopeClaim := a.config.ScopeClaim
+	if scopeClaim == "" {
+		scopeClaim = defaultScopeClaim
+	}
+	// 没有调用方标识的令牌会共用会话、配额和异步任务,直接拒绝
+	client, _ := claims[clientClaim].(string)
+	if client == "" || a.reserved[client] {
+		return nil, ErrUnauthorized
+	}
+	return &Identity{Client: client, Method: "jwt", Scopes: claimScopes(claims[scopeClaim])}, nil
+}
+
+// 按kid选择密钥,只有一个密钥时可省略kid
+func (a *jwtAuth) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("未找到密钥:%s", kid)
+}
+
+func claimScopes(v interface{}) []string {
+	switch val := v.(type) {
+	case string:
+		return strings.Fields(val)
+	case []interface{}:
+		scopes := make([]string, 0, len(val))
+		for _, s := range val {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
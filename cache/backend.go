@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const diskSweepInterval = 10 * time.Minute
+
+// 缓存条目,值为JSON
+type Entry struct {
+	Value      json.RawMessage
+	Created    time.Time
+	Expires    time.Time //过期时间
+	StaleUntil time.Time //过期后仍可返回的截止时间
+}
+
+// 缓存存储
+type Backend interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+}
+
+// 内存存储,超过条目上限时先清理过期条目,再淘汰最早创建的条目
+type memoryBackend struct {
+	lock       sync.Mutex
+	entries    map[string]*Entry
+	maxEntries int
+}
+
+func newMemoryBackend(maxEntries int) *memoryBackend {
+	return &memoryBackend{entries: make(map[string]*Entry), maxEntries: maxEntries}
+}
+
+func (m *memoryBackend) Get(key string) (*Entry, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry, ok := m.entries[key]
+	return entry, ok
+}
+
+func (m *memoryBackend) Set(key string, entry *Entry) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		m.evict(time.Now())
+	}
+	m.entries[key] = entry
+}
+
+func (m *memoryBackend) Delete(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.entries, key)
+}
+
+func (m *memoryBackend) evict(now time.Time) {
+	var oldestKey string
+	var oldest *Entry
+	for k, e := range m.entries {
+		if now.After(e.StaleUntil) {
+			delete(m.entries, k)
+			continue
+		}
+		if oldest == nil || e.Created.Before(oldest.Created) {
+			oldestKey, oldest = k, e
+		}
+	}
+	if len(m.entries) >= m.maxEntries && oldest != nil {
+		delete(m.entries, oldestKey)
+	}
+}
+
+// 磁盘存储,每个条目保存为一个文件,文件名为键的SHA256
+// 文件的修改时间设为条目可返回的截止时间,定期删除已过期的文件,超过条目上限时先删除截止时间最早的文件
+type diskBackend struct {
+	dir        string
+	maxEntries int
+}
+
+func newDiskBackend(dir string, maxEntries int) (*diskBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &diskBackend{dir: dir, maxEntries: maxEntries}
+	go d.maintain()
+	return d, nil
+}
+
+func (d *diskBackend) maintain() {
+	ticker := time.NewTicker(diskSweepInterval)
+	defer ticker.Stop()
+	for {
+		d.sweep(time.Now())
+		<-ticker.C
+	}
+}
+
+func (d *diskBackend) sweep(now time.Time) {
+	files, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	var live []os.FileInfo
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		// 未完成重命名的临时文件超过一个清理周期后删除
+		if strings.HasPrefix(f.Name(), "tmp-") {
+			if now.Sub(f.ModTime()) > diskSweepInterval {
+				_ = os.Remove(filepath.Join(d.dir, f.Name()))
+			}
+			continue
+		}
+		if now.After(f.ModTime()) {
+			_ = os.Remove(filepath.Join(d.dir, f.Name()))
+			continue
+		}
+		live = append(live, f)
+	}
+	if len(live) <= d.maxEntries {
+		return
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].ModTime().Before(live[j].ModTime())
+	})
+	for _, f := range live[:len(live)-d.maxEntries] {
+		_ = os.Remove(filepath.Join(d.dir, f.Name()))
+	}
+}
+
+func (d *diskBackend) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (d *diskBackend) Get(key string) (*Entry, bool) {
+	data, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// 先写入临时文件再重命名,避免读到写了一半的文件
+func (d *diskBackend) Set(key string, entry *Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(d.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	_ = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Chtimes(tmp.Name(), entry.StaleUntil, entry.StaleUntil); err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), d.path(key)); err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+func (d *diskBackend) Delete(key string) {
+	_ = os.Remove(d.path(key))
+}
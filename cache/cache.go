@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const (
+	defaultMaxEntries = 10000
+	defaultDir        = "cache"
+)
+
+// 结果的缓存状态
+const (
+	StatusHit    = "HIT"
+	StatusMiss   = "MISS"
+	StatusStale  = "STALE"
+	StatusBypass = "BYPASS"
+)
+
+// 执行任务并返回结果
+type Loader func() (map[string]interface{}, error)
+
+// 任务结果缓存,相同键的并发请求只执行一次
+type Cache struct {
+	backend Backend
+	lock    sync.Mutex
+	calls   map[string]*call
+}
+
+// 执行中的请求
+type call struct {
+	done  chan struct{}
+	value json.RawMessage
+	err   error
+}
+
+func New(c *cfg.CacheStore) (*Cache, error) {
+	cache := &Cache{calls: make(map[string]*call)}
+	maxEntries := defaultMaxEntries
+	if c != nil && c.MaxEntries > 0 {
+		maxEntries = c.MaxEntries
+	}
+	if c != nil && c.Backend == cfg.CACHE_DISK {
+		dir := c.Dir
+		if dir == "" {
+			dir = defaultDir
+		}
+		if !filepath.IsAbs(dir) {
+			appPath, _ := cfg.AppPath()
+			dir = filepath.Join(appPath, dir)
+		}
+		backend, err := newDiskBackend(dir, maxEntries)
+		if err != nil {
+			return nil, err
+		}
+		cache.backend = backend
+		return cache, nil
+	}
+	cache.backend = newMemoryBackend(maxEntries)
+	return cache, nil
+}
+
+// 缓存键,由任务的完整名称和参数组成,参数按键排序序列化
+func Key(task *cfg.Task, inputs map[string]interface{}) string {
+	data, _ := json.Marshal(inputs)
+	return task.FullName() + "\n" + string(data)
+}
+
+// 按任务的缓存规则取得结果
+// 未过期时直接返回;过期但在Stale时间内时返回旧结果并在后台刷新;bypass为true时不读取缓存,但更新缓存
+func (c *Cache) Get(key string, rule *cfg.CacheRule, bypass bool, load Loader) (map[string]interface{}, string, error) {
+	now := time.Now()
+	if !bypass {
+		if entry, ok := c.backend.Get(key); ok {
+			if now.Before(entry.Expires) {
+				rst, err := decode(entry.Value)
+				return rst, StatusHit, err
+			}
+			if now.Before(entry.StaleUntil) {
+				go c.refresh(key, rule, load)
+				rst, err := decode(entry.Value)
+				return rst, StatusStale, err
+			}
+			c.backend.Delete(key)
+		}
+	}
+	value, err := c.do(key, rule, load)
+	if err != nil {
+		return nil, "", err
+	}
+	rst, err := decode(value)
+	if bypass {
+		return rst, StatusBypass, err
+	}
+	return rst, StatusMiss, err
+}
+
+func (c *Cache) refresh(key string, rule *cfg.CacheRule, load Loader) {
+	if _, err := c.do(key, rule, load); err != nil {
+		log.Println("后台刷新缓存失败:", err)
+	}
+}
+
+// 执行任务并写入缓存,相同键的请求执行中时等待其结果
+func (c *Cache) do(key string, rule *cfg.CacheRule, load Loader) (json.RawMessage, error) {
+	c.lock.Lock()
+	if running, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		<-running.done
+		return running.value, running.err
+	}
+	current := &call{done: make(chan struct{})}
+	c.calls[key] = current
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		close(current.done)
+	}()
+	rst, err := load()
+	if err != nil {
+		current.err = err
+		return nil, err
+	}
+	current.value, current.err = json.Marshal(rst)
+	if current.err != nil {
+		return nil, current.err
+	}
+	now := time.Now()
+	expires := now.Add(time.Duration(rule.Ttl) * time.Second)
+	c.backend.Set(key, &Entry{
+		Value:      current.value,
+		Created:    now,
+		Expires:    expires,
+		StaleUntil: expires.Add(time.Duration(rule.Stale) * time.Second),
+	})
+	return current.value, nil
+}
+
+// 每个调用方得到独立的结果,避免修改影响其他调用方
+func decode(value json.RawMessage) (map[string]interface{}, error) {
+	rst := make(map[string]interface{})
+	err := json.Unmarshal(value, &rst)
+	return rst, err
+}
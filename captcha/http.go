@@ -0,0 +1,39 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 调用本地识别服务,图片作为请求体POST,响应体为识别结果
+type httpSolver struct {
+	endpoint string
+	timeout  time.Duration
+}
+
+func (s *httpSolver) Solve(ctx context.Context, image []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(image))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	client := &http.Client{Timeout: s.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("识别服务返回状态码%d", resp.StatusCode)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
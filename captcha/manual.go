@@ -0,0 +1,100 @@
+package captcha
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 等待人工输入的验证码
+type Pending struct {
+	Id          string
+	ContentType string
+	Created     time.Time
+	image       []byte
+	answer      chan string
+}
+
+var (
+	pendingLock sync.Mutex
+	pending     = make(map[string]*Pending)
+)
+
+// 通过管理接口展示图片,等待人工输入答案
+type manualSolver struct {
+	timeout time.Duration
+}
+
+func (s *manualSolver) Solve(ctx context.Context, image []byte, contentType string) (string, error) {
+	p := &Pending{Id: newId(), ContentType: contentType, Created: time.Now(), image: image, answer: make(chan string, 1)}
+	pendingLock.Lock()
+	pending[p.Id] = p
+	pendingLock.Unlock()
+	defer func() {
+		pendingLock.Lock()
+		delete(pending, p.Id)
+		pendingLock.Unlock()
+	}()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case answer := <-p.answer:
+		return answer, nil
+	case <-timer.C:
+		return "", fmt.Errorf("等待人工输入验证码[%s]超时", p.Id)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// 等待人工输入的验证码列表,按创建时间排序
+func PendingList() []*Pending {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	list := make([]*Pending, 0, len(pending))
+	for _, p := range pending {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+// 取得验证码图片
+func Image(id string) ([]byte, string, bool) {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	p, ok := pending[id]
+	if !ok {
+		return nil, "", false
+	}
+	return p.image, p.ContentType, true
+}
+
+// 提交人工输入的答案,验证码不存在或已回答时返回false
+func Answer(id string, answer string) bool {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	p, ok := pending[id]
+	if !ok {
+		return false
+	}
+	select {
+	case p.answer <- answer:
+		return true
+	default:
+		return false
+	}
+}
+
+func newId() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
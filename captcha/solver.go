@@ -0,0 +1,48 @@
+package captcha
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const (
+	defaultTimeout       = 30 * time.Second
+	defaultManualTimeout = 300 * time.Second
+)
+
+// 验证码识别器,ctx取消时停止识别
+type Solver interface {
+	Solve(ctx context.Context, image []byte, contentType string) (string, error)
+}
+
+// 按配置创建识别器
+func NewSolver(c *cfg.Captcha) (Solver, error) {
+	timeout := time.Duration(c.Timeout) * time.Second
+	switch c.Solver {
+	case cfg.SOLVER_HTTP:
+		if c.Endpoint == "" {
+			return nil, fmt.Errorf("http识别方式需要设置Endpoint")
+		}
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		return &httpSolver{endpoint: c.Endpoint, timeout: timeout}, nil
+	case cfg.SOLVER_EXEC:
+		if c.Command == "" {
+			return nil, fmt.Errorf("exec识别方式需要设置Command")
+		}
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		return &execSolver{command: c.Command, args: c.Args, timeout: timeout}, nil
+	case cfg.SOLVER_MANUAL:
+		if timeout <= 0 {
+			timeout = defaultManualTimeout
+		}
+		return &manualSolver{timeout: timeout}, nil
+	}
+	return nil, fmt.Errorf("不支持的验证码识别方式:%s", c.Solver)
+}
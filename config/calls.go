@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultLoginTask = "login"
+
+// 获取默认站点的登录任务
+func (c *Config) GetLoginTask() *Task {
+	return c.DefaultSite().GetLoginTask()
+}
+
+// 检查任务调用的目标是否存在,以及是否存在循环调用
+func (c *Config) checkCalls() error {
+	// 0:未访问 1:访问中 2:已完成
+	state := make(map[*Task]int)
+	var path []string
+	var visit func(t *Task) error
+	visit = func(t *Task) error {
+		switch state[t] {
+		case 1:
+			return fmt.Errorf("任务存在循环调用:%s -> %s", strings.Join(path, " -> "), t.FullName())
+		case 2:
+			return nil
+		}
+		state[t] = 1
+		path = append(path, t.FullName())
+		for _, name := range calledTasks(t.Steps) {
+			called := c.ResolveTask(t.site, name)
+			if called == nil {
+				return fmt.Errorf("任务[%s]调用的任务[%s]不存在", t.FullName(), name)
+			}
+			if err := visit(called); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[t] = 2
+		return nil
+	}
+	for _, site := range c.AllSites() {
+		for _, t := range site.Tasks {
+			if err := visit(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 步骤中调用的任务名称,包括循环中的子步骤
+func calledTasks(steps []*Step) []string {
+	var names []string
+	for _, s := range steps {
+		if s == nil {
+			continue
+		}
+		if s.Call != nil {
+			names = append(names, s.Call.Task)
+		}
+		if s.ForEach != nil {
+			names = append(names, calledTasks(s.ForEach.Steps)...)
+		}
+	}
+	return names
+}
@@ -22,6 +22,71 @@ const (
 	TYPE_CSS   = "css"
 	TYPE_JSON  = "json"
 	TYPE_REGEX = "reg"
+	TYPE_TABLE = "table"
+	TYPE_XPATH = "xpath"
+)
+
+const (
+	PAGING_NEXT  = "next"
+	PAGING_PARAM = "param"
+)
+
+const (
+	OP_EQ     = "eq"
+	OP_NE     = "ne"
+	OP_EXISTS = "exists"
+	OP_REGEX  = "regex"
+	OP_GT     = "gt"
+	OP_GE     = "ge"
+	OP_LT     = "lt"
+	OP_LE     = "le"
+	OP_AND    = "and"
+	OP_OR     = "or"
+	OP_NOT    = "not"
+
+	OP_NOT_EMPTY = "notempty"
+	OP_MIN_LEN   = "minlen"
+	OP_RANGE     = "range"
+)
+
+const (
+	ACTION_GOTO   = "goto"
+	ACTION_SKIP   = "skip"
+	ACTION_RETURN = "return"
+	ACTION_FAIL   = "fail"
+)
+
+const (
+	POLICY_FAIL_FAST = "failfast"
+	POLICY_COLLECT   = "collect"
+	POLICY_CONTINUE  = "continue"
+)
+
+const (
+	SCOPE_STRIP = "strip"
+	SCOPE_TIDY  = "tidy"
+)
+
+const (
+	OVERLAP_SKIP  = "skip"
+	OVERLAP_QUEUE = "queue"
+	OVERLAP_ALLOW = "allow"
+)
+
+const (
+	CACHE_MEMORY = "memory"
+	CACHE_DISK   = "disk"
+)
+
+const (
+	STRATEGY_ROUND_ROBIN  = "roundrobin"
+	STRATEGY_LEAST_LOADED = "leastloaded"
+)
+
+const (
+	SOLVER_HTTP   = "http"
+	SOLVER_EXEC   = "exec"
+	SOLVER_MANUAL = "manual"
 )
 
 type KeyValuePair struct {
@@ -32,15 +97,16 @@ type KeyValuePair struct {
 // 参数
 type Param struct {
 	KeyValuePair
-	IsConst bool //是否常量
+	IsConst bool   //是否常量
+	Expr    string //表达式,设置后忽略Value和IsConst
 }
 
 // 表示HTTP请求
 type Req struct {
-	Url          string            //地址
-	UrlParams    map[string]string //地址参数
+	Url          string            //地址,可包含${表达式}
+	UrlParams    map[string]string //地址参数,占位符:上下文路径或包含${表达式}的字符串
 	Method       string            //Http方法
-	Headers      map[string]string //请求头
+	Headers      map[string]string //请求头,值可包含${表达式}
 	Params       []*Param          //请求参数
 	TemplatePath string            // 模板路径
 	Encoding     string            //编码方式
@@ -48,73 +114,392 @@ type Req struct {
 
 // 代表响应数据中单项的解析规则
 type ItemRule struct {
-	Type  string //XPATH,JSON,CSS,REGEX
-	Expr  string //表达式
-	Key   string // 键
-	Regex string // 对结果惊醒处理的正则表达式
+	Type     string //XPATH,JSON,CSS,REGEX
+	Expr     string //表达式
+	Key      string // 键
+	Regex    string // 对结果惊醒处理的正则表达式
+	Attr     string // CSS规则取元素属性而不是文本,如href
+	Required bool   // 是否必填,未提取到内容时步骤失败
+	Default  string // 未提取到内容时的默认值
 }
 
 // 表示响应数据中集合的解析规则
 type CollectionRule struct {
-	Type      string      //XPATH,JSON
-	Expr      string      //表达式,返回一个集合
-	ItemRules []*ItemRule //子项规则
-	Key       string      // 键
+	Type       string            //XPATH,JSON,CSS,TABLE
+	Expr       string            //表达式,返回一个集合;TABLE时为表格的CSS选择器
+	ItemRules  []*ItemRule       //子项规则
+	Key        string            // 键
+	HeaderRows int               // TABLE表头行数,为0时自动识别
+	Renames    map[string]string // TABLE列名映射,表头文本:键
+}
+
+// 范围处理规则
+type ScopeRule struct {
+	Type string //reg,css,json,strip,tidy
+	Expr string //表达式,strip和tidy不需要
+}
+
+// 范围处理链,按顺序缩小响应内容
+type Scope []*ScopeRule
+
+// 兼容旧配置中以字符串表示的正则表达式
+func (s *Scope) UnmarshalJSON(data []byte) error {
+	var regex string
+	if err := json.Unmarshal(data, &regex); err == nil {
+		if regex == "" {
+			*s = nil
+		} else {
+			*s = Scope{{Type: TYPE_REGEX, Expr: regex}}
+		}
+		return nil
+	}
+	var rules []*ScopeRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	*s = rules
+	return nil
+}
+
+// 表示嵌入在页面脚本或JSONP中的JSON数据
+type ScriptSource struct {
+	Selector string //脚本所在元素的CSS选择器,为空时在整个响应中查找
+	Variable string //变量名,如window.__INITIAL_STATE__
+	Callback string //JSONP回调函数名,Variable为空时使用,为空时匹配任意回调
 }
 
 // 表示HTTP响应
 type Res struct {
 	Extract         bool              //是否对结果经行处理
-	Scope           string            //通过正则表达式限制结果范围
+	Scope           Scope             //范围处理链,在规则提取前缩小结果范围
+	Script          *ScriptSource     //从脚本或JSONP中提取JSON后再应用规则
 	Encoding        string            //编码方式
 	ItemRules       []*ItemRule       //单条规则
 	CollectionRules []*CollectionRule //集合规则
-	Check           *Param            //校验规则
+	Diagnostics     bool              //是否在结果中输出未匹配规则的诊断信息
+	Namespaces      map[string]string //XPATH命名空间,前缀:URI
+	Feed            bool              //是否将RSS/Atom订阅转换为统一的Items集合
+	Check           *Param            //校验规则,建议使用Checks
+	Checks          []*Assertion      //结果断言,按顺序校验
+}
+
+// 对步骤结果的断言
+type Assertion struct {
+	Op      string   //eq,ne,regex,exists,notempty,minlen,range,默认为eq
+	Key     string   //结果中的路径,如 list.0.Title
+	Value   string   //eq、ne的比较值,regex的正则表达式,minlen的最小长度
+	Expr    string   //比较值表达式,基于上下文计算,设置后替代Value
+	Min     *float64 //range的最小值,包含
+	Max     *float64 //range的最大值,包含
+	Code    string   //断言失败时返回的错误码
+	Message string   //断言失败时返回的错误信息
+	Warn    bool     //为true时只记录警告,不中断任务
+}
+
+// 分页规则
+type Paging struct {
+	Mode         string //next:按结果中的下一页地址翻页;param:递增页码参数
+	NextKey      string //next模式下,结果中下一页地址的键
+	Param        string //param模式下,页码在上下文中的键,由Params或UrlParams引用
+	Start        int    //param模式下的起始页码
+	Increment    int    //param模式下每页的增量,按偏移量翻页时为每页条数,默认为1
+	Collection   string //需要跨页拼接的集合键
+	MaxPages     int    //最大页数,默认为100
+	PageCountKey string //页数在结果中的键,默认为pageCount
+}
+
+// 条件,Op为and/or/not时由Conditions组合
+type Condition struct {
+	Op         string       //eq,ne,exists,regex,gt,ge,lt,le,and,or,not,默认为eq
+	Expr       string       //布尔表达式,设置后忽略其他字段
+	Key        string       //上下文中的键
+	Value      string       //比较的值,regex时为正则表达式
+	Conditions []*Condition //子条件
+}
+
+// 步骤执行后的动作
+type Action struct {
+	When    *Condition //触发条件,为空时总是触发
+	Do      string     //goto:跳转到Target步骤;skip:跳过之后Target个步骤;return:结束任务;fail:任务失败
+	Target  string     //goto的步骤名称,skip的步骤数量(默认为1)
+	Message string     //fail时返回的错误信息
+}
+
+// 循环,对集合中的每个元素执行子步骤
+type Loop struct {
+	Collection string  //上下文中集合的路径
+	Item       string  //元素在上下文中的键,默认为item
+	Steps      []*Step //子步骤,对象元素的字段可在上下文中直接引用,结果合并回元素
+	Parallel   int     //同时处理的元素数量,默认为1
+}
+
+// 调用其他任务
+type TaskCall struct {
+	Task    string            //任务名称
+	Inputs  []*Param          //传入的参数,Key为子任务上下文中的键
+	Outputs map[string]string //子任务结果的键:当前任务中的键,为空时合并全部结果
 }
 
 // 步骤
 type Step struct {
-	Sort   int  //序号
-	Input  *Req //输入
-	Output *Res //输出
+	Name    string     //名称,作为goto的目标
+	Sort    int        //序号
+	Group   string     //并行组,相邻且组名相同的步骤并发执行
+	When    *Condition //执行条件,不满足时跳过该步骤
+	Input   *Req       //输入
+	Output  *Res       //输出
+	Paging  *Paging    //分页规则
+	ForEach *Loop      //循环,设置后不使用Input和Output
+	Call    *TaskCall  //调用其他任务,设置后不使用Input和Output
+	Then    []*Action  //执行后按顺序检查,执行第一个满足条件的动作
+	Script  *Script    //请求前后执行的JavaScript脚本
+	Captcha *Captcha   //下载验证码并识别,设置后不使用Input和Output
+}
+
+// 验证码识别,在同一会话中下载图片,识别结果写入上下文
+type Captcha struct {
+	Url      string   //验证码图片地址,支持${}表达式
+	Key      string   //识别结果在上下文中的键,默认为captcha
+	Solver   string   //识别方式,http:调用识别服务;exec:执行命令;manual:通过管理接口人工输入
+	Endpoint string   //http方式的识别服务地址,图片作为请求体POST,响应体为识别结果
+	Command  string   //exec方式的命令,图片写入标准输入,标准输出为识别结果
+	Args     []string //exec方式的命令参数
+	Timeout  int      //识别超时时间,秒,manual默认300,其它默认30
+}
+
+// 步骤脚本,脚本中可通过ctx访问上下文,通过request访问请求,通过result访问解析结果
+type Script struct {
+	Before    string //请求前执行,可修改request的url、method、headers和body
+	After     string //解析后执行,可修改或替换result
+	Timeout   int    //超时时间,毫秒,默认1000
+	MaxMemory int    //内存上限,MB,默认64,脚本在独立的子进程中执行
+}
+
+// 并行组的失败策略
+type StepGroup struct {
+	Name   string //组名,Task.ParallelSort时Sort相同的步骤以Sort为组名
+	Policy string //failfast:立即失败(默认);collect:等待全部完成后失败;continue:记录错误并继续
+}
+
+// 任务结果中的字段
+type OutputField struct {
+	Key      string //结果中的键,以点分隔时生成嵌套对象
+	From     string //上下文中的路径
+	Template string //mustache模板,以上下文渲染,设置后忽略From
+	Expr     string //表达式,设置后忽略From和Template
+	Json     bool   //模板是否为JSON,{{path}}替换为该路径的值序列化后的JSON,如{"id":{{item.id}},"name":{{item.name}}}
 }
 
 // 任务
 type Task struct {
-	Name  string  //名称
-	Steps []*Step //步骤
+	Name         string         //名称
+	Steps        []*Step        //步骤
+	ParallelSort bool           //Sort相同的相邻步骤是否并发执行
+	Groups       []*StepGroup   //并行组的失败策略
+	Export       []string       //结果中包含的上下文路径,为空时包含全部步骤结果
+	Output       []*OutputField //结果定义,设置后忽略Export
+	Cache        *CacheRule     //结果缓存,调用方自带账号时不使用缓存
+	Overrides    []string       //调用方可以覆盖的预设参数,未列出的预设参数(包括站点和账号参数)忽略调用方传入的值
+	site         *Site
+}
+
+// 任务所属的站点
+func (t *Task) Site() *Site {
+	return t.site
+}
+
+// 过滤调用方传入的参数,去掉不允许覆盖的预设参数
+func (c *Config) CallerInputs(task *Task, data map[string]interface{}) map[string]interface{} {
+	if len(data) == 0 {
+		return data
+	}
+	preset := make(map[string]bool)
+	addKeys := func(args []*KeyValuePair) {
+		for _, p := range args {
+			preset[p.Key] = true
+		}
+	}
+	addKeys(c.Arguments)
+	if site := task.Site(); site != nil {
+		addKeys(site.Arguments)
+		for _, a := range site.Accounts {
+			addKeys(a.Arguments)
+		}
+	}
+	for _, k := range task.Overrides {
+		delete(preset, k)
+	}
+	inputs := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		if preset[k] {
+			log.Println("忽略调用方传入的预设参数:", k)
+			continue
+		}
+		inputs[k] = v
+	}
+	return inputs
+}
+
+// 异步任务队列设置
+type JobQueue struct {
+	Workers   int    //同时执行的任务数,默认为4
+	QueueSize int    //排队任务数上限,默认为1000
+	Dir       string //任务的保存目录,相对路径基于程序目录,默认为jobs
+	Keep      int    //已结束任务的保留时间,小时,默认为24
+}
+
+// 任务的保存目录,未设置时为程序目录下的jobs
+func (q *JobQueue) Path() string {
+	dir := "jobs"
+	if q != nil && q.Dir != "" {
+		dir = q.Dir
+	}
+	if !filepath.IsAbs(dir) {
+		appPath, _ := AppPath()
+		dir = filepath.Join(appPath, dir)
+	}
+	return dir
+}
+
+// 定时执行的任务
+type Schedule struct {
+	Name    string                 //名称
+	Cron    string                 //cron表达式,秒字段可选,也支持@hourly、@every 10m等写法
+	Task    string                 //任务名称,其他站点的任务使用 站点名/任务名
+	Inputs  map[string]interface{} //任务参数
+	Overlap string                 //上次执行未结束时的策略,skip:跳过本次;queue:等待上次结束后执行;allow:同时执行,默认为skip
+	Keep    int                    //保存最近的执行结果数,默认为10,执行结果保存在任务目录的schedules目录中,重启后恢复
+	Diff    *DiffRule              //与上次成功的结果比较,有变化时通知
+}
+
+// 结果比较规则
+type DiffRule struct {
+	Collection string     //结果中集合的路径,为空时将整个结果作为一个对象比较
+	Key        string     //集合元素的标识键,可以是以点分隔的路径
+	Fields     []string   //比较的字段,为空时比较全部字段
+	Webhooks   []*Webhook //有变化时通知的地址
+}
+
+// 通知地址,请求体为JSON格式的通知内容
+type Webhook struct {
+	Url     string //通知地址
+	Secret  string //签名密钥,签名为 hex(HMAC-SHA256(secret, 时间戳\n请求体)),通过X-Signature请求头传递
+	Retries *int   //失败后的重试次数,默认为3,为0时不重试
+	Timeout int    //请求超时时间,秒,默认为10
+}
+
+// 任务结果缓存,按任务名称和参数缓存
+type CacheRule struct {
+	Ttl   int //缓存有效时间,秒
+	Stale int //过期后仍返回旧结果的时间,秒,期间在后台刷新缓存
+}
+
+// 缓存存储设置
+type CacheStore struct {
+	Backend    string //memory:内存;disk:磁盘,默认为memory
+	Dir        string //disk方式的目录,相对路径基于程序目录,默认为cache
+	MaxEntries int    //最多保存的条目数,默认10000,disk方式定期清理时检查
 }
 
 // 配置文件的GO表示
 type Config struct {
 	Port      int             //端口号
-	AesKey    string          //加密Key
-	CheckData string          //校验数据
+	Auth      *Auth           //调用方认证设置
+	Clients   []*ClientPolicy //调用方的任务权限和配额
+	Cache     *CacheStore     //任务结果缓存的存储设置
+	Schedules []*Schedule     //定时执行的任务
+	Jobs      *JobQueue       //异步任务队列设置
 	Arguments []*KeyValuePair //预设参数
+	LoginTask string          //登录任务名称,默认为login
+	Accounts  []*Account      //上游账号,为空时使用Arguments作为唯一账号
+	Pool      *AccountPool    //账号池设置
+	Sessions  *SessionPolicy  //调用方自带账号时的会话设置
 	Tasks     []*Task         //任务列表
+	Sites     []*Site         //其他站点,站点中的任务以 站点名/任务名 调用
+	sites     []*Site
 }
 
-// 获取指定名称的Task
+// 调用方认证设置,按API Key、HMAC签名、JWT的顺序尝试
+type Auth struct {
+	ApiKeys []*ApiKey //静态API Key
+	Hmac    *HmacAuth //HMAC签名认证
+	Jwt     *JwtAuth  //JWT认证
+}
+
+// 调用方的任务权限和配额,按认证得到的调用方名称匹配
+type ClientPolicy struct {
+	Client    string     //调用方名称
+	Tasks     []string   //允许调用的任务,格式同ApiKey的Scopes,为空时只按认证方式的Scopes限制
+	PerMinute int        //每分钟请求数上限,0为不限制
+	PerDay    int        //每天请求数上限,0为不限制
+	Callbacks []*Webhook //异步任务允许使用的回调地址,回调按Secret签名,未配置时不能设置回调
+}
+
+// 静态API Key,通过X-Api-Key请求头传递
+type ApiKey struct {
+	Client   string   //调用方名称
+	Key      string   //密钥
+	Scopes   []string //可调用的任务,*为全部任务,site/*为站点中的全部任务,admin为管理接口
+	Disabled bool     //是否停用
+}
+
+// HMAC签名认证
+type HmacAuth struct {
+	Clients []*HmacClient //调用方
+	MaxSkew int           //请求时间与服务器时间允许的最大偏差,秒,默认300
+}
+
+// HMAC签名的调用方
+type HmacClient struct {
+	Client string   //调用方名称,通过X-Client请求头传递
+	Secret string   //签名密钥
+	Scopes []string //可调用的任务,格式同ApiKey
+}
+
+// JWT认证,通过Authorization: Bearer请求头传递
+type JwtAuth struct {
+	JwksFile    string //JWKS文件路径,相对路径基于配置目录
+	Issuer      string //签发者,为空时不校验
+	Audience    string //受众,为空时不校验
+	ClientClaim string //调用方名称的声明,默认为sub
+	ScopeClaim  string //权限的声明,值为空格分隔的字符串或数组,默认为scope
+}
+
+// 调用方会话设置
+type SessionPolicy struct {
+	IdleTimeout int //会话空闲超时时间,秒,默认1800
+	MaxSessions int //最多保存的会话数,超过时淘汰最久未使用的会话,默认1000
+}
+
+// 上游账号,每个账号使用独立的会话和登录状态
+type Account struct {
+	Name      string          //账号名称,用于管理接口展示
+	Arguments []*KeyValuePair //账号参数,覆盖预设参数,如用户名和密码
+}
+
+// 账号池设置
+type AccountPool struct {
+	Strategy   string //账号选择策略,roundrobin:轮询;leastloaded:选择执行中任务最少的账号,默认为roundrobin
+	RetryAfter int    //登录失败的账号被剔除后,重新尝试登录的间隔,秒,默认为60
+}
+
+// 获取指定名称的Task,其他站点的任务使用 站点名/任务名
 func (c *Config) GetTaskByName(name string) *Task {
-	if c.Tasks == nil || len(c.Tasks) == 0 {
-		return nil
-	}
-	for _, t := range c.Tasks {
-		if t.Name == name {
-			return t
-		}
-	}
-	return nil
+	return c.ResolveTask(c.DefaultSite(), name)
 }
 
-// 对Step进行排序
+// 对Step进行排序,包括循环中的子步骤
 func (t *Task) SortSteps() {
-	if t.Steps == nil || len(t.Steps) == 0 {
+	sortSteps(t.Steps)
+}
+
+func sortSteps(steps []*Step) {
+	if steps == nil || len(steps) == 0 {
 		return
 	}
-	sort.Slice(t.Steps, func(l, r int) bool {
-		left := t.Steps[l]
-		right := t.Steps[r]
+	sort.SliceStable(steps, func(l, r int) bool {
+		left := steps[l]
+		right := steps[r]
 		if left == nil {
 			return true
 		}
@@ -123,6 +508,11 @@ func (t *Task) SortSteps() {
 		}
 		return left.Sort < right.Sort
 	})
+	for _, s := range steps {
+		if s != nil && s.ForEach != nil {
+			sortSteps(s.ForEach.Steps)
+		}
+	}
 }
 
 // 获取配置 单例
@@ -167,11 +557,19 @@ func readApplicationConfig() (*Config, error) {
 		log.Println(err4)
 		return nil, errors.New("读取配置发生错误")
 	}
-	if appConfig.Tasks != nil {
-		for _, t := range appConfig.Tasks {
+	if err := appConfig.initSites(); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, site := range appConfig.sites {
+		for _, t := range site.Tasks {
 			t.SortSteps()
 		}
 	}
+	if err := appConfig.checkCalls(); err != nil {
+		log.Println(err)
+		return nil, err
+	}
 
 	return appConfig, nil
 }
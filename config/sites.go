@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// 站点名与任务名的分隔符
+const SiteSeparator = "/"
+
+// 上游站点,每个站点使用独立的登录任务、账号和会话
+type Site struct {
+	Name      string            //站点名称
+	BaseUrl   string            //基础地址,步骤中的相对地址基于此地址
+	LoginTask string            //登录任务名称,默认为login
+	Arguments []*KeyValuePair   //站点预设参数,覆盖全局预设参数
+	Headers   map[string]string //默认请求头,步骤中的同名请求头优先
+	Encoding  string            //默认编码,步骤未设置编码时使用
+	Transport *Transport        //传输设置
+	Accounts  []*Account        //上游账号,为空时使用预设参数作为唯一账号
+	Pool      *AccountPool      //账号池设置
+	Tasks     []*Task           //任务列表
+}
+
+// 传输设置
+type Transport struct {
+	Timeout             int    //请求超时时间,秒,默认不超时
+	Proxy               string //代理地址,如 http://127.0.0.1:8080
+	InsecureSkipVerify  bool   //是否跳过证书校验
+	MaxIdleConnsPerHost int    //每个主机保持的空闲连接数
+}
+
+// 获取站点的登录任务
+func (s *Site) GetLoginTask() *Task {
+	name := s.LoginTask
+	if name == "" {
+		name = defaultLoginTask
+	}
+	return s.GetTask(name)
+}
+
+// 获取站点中指定名称的任务
+func (s *Site) GetTask(name string) *Task {
+	for _, t := range s.Tasks {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// 任务的完整名称,默认站点的任务不带站点名
+func (t *Task) FullName() string {
+	if t.site == nil || t.site.Name == "" {
+		return t.Name
+	}
+	return t.site.Name + SiteSeparator + t.Name
+}
+
+// 默认站点,由顶层的登录任务、账号和任务组成
+func (c *Config) DefaultSite() *Site {
+	if len(c.sites) == 0 {
+		site := c.defaultSite()
+		for _, t := range site.Tasks {
+			t.site = site
+		}
+		c.sites = []*Site{site}
+	}
+	return c.sites[0]
+}
+
+// 所有站点,第一个为默认站点
+func (c *Config) AllSites() []*Site {
+	c.DefaultSite()
+	return c.sites
+}
+
+// 获取指定名称的站点,空字符串为默认站点
+func (c *Config) GetSite(name string) *Site {
+	for _, s := range c.AllSites() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// 解析任务名称,带站点名时在对应站点中查找,否则在from站点中查找
+func (c *Config) ResolveTask(from *Site, name string) *Task {
+	if i := strings.Index(name, SiteSeparator); i >= 0 {
+		site := c.GetSite(name[:i])
+		if site == nil {
+			return nil
+		}
+		return site.GetTask(name[i+len(SiteSeparator):])
+	}
+	if from == nil {
+		from = c.DefaultSite()
+	}
+	return from.GetTask(name)
+}
+
+func (c *Config) defaultSite() *Site {
+	return &Site{LoginTask: c.LoginTask, Accounts: c.Accounts, Pool: c.Pool, Tasks: c.Tasks}
+}
+
+// 建立站点列表,关联任务与站点,并将站点的默认编码应用到步骤
+func (c *Config) initSites() error {
+	c.sites = []*Site{c.defaultSite()}
+	names := make(map[string]bool, len(c.Sites))
+	for _, s := range c.Sites {
+		if s.Name == "" || strings.Contains(s.Name, SiteSeparator) {
+			return fmt.Errorf("站点名称不能为空或包含%s:%s", SiteSeparator, s.Name)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("站点名称重复:%s", s.Name)
+		}
+		names[s.Name] = true
+		if s.Transport != nil && s.Transport.Proxy != "" {
+			if _, err := url.Parse(s.Transport.Proxy); err != nil {
+				return fmt.Errorf("站点[%s]的代理地址错误:%v", s.Name, err)
+			}
+		}
+		c.sites = append(c.sites, s)
+	}
+	for _, s := range c.sites {
+		for _, t := range s.Tasks {
+			t.site = s
+			if s.Encoding != "" {
+				applyEncoding(t.Steps, s.Encoding)
+			}
+		}
+	}
+	return nil
+}
+
+func applyEncoding(steps []*Step, encoding string) {
+	for _, step := range steps {
+		if step == nil {
+			continue
+		}
+		if step.Input != nil && step.Input.Encoding == "" {
+			step.Input.Encoding = encoding
+		}
+		if step.Output != nil && step.Output.Encoding == "" {
+			step.Output.Encoding = encoding
+		}
+		if step.ForEach != nil {
+			applyEncoding(step.ForEach.Steps, encoding)
+		}
+	}
+}
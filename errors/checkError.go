@@ -1,11 +1,21 @@
 package errors
 
+const DefaultCheckCode = "CHECK_FAILED"
+
 type CheckError struct {
-	msg string
+	Code string
+	msg  string
 }
 
 func NewCheckError(msg string) *CheckError {
-	return &CheckError{msg: msg}
+	return &CheckError{Code: DefaultCheckCode, msg: msg}
+}
+
+func NewCheckErrorWithCode(code string, msg string) *CheckError {
+	if code == "" {
+		code = DefaultCheckCode
+	}
+	return &CheckError{Code: code, msg: msg}
 }
 
 func (c CheckError) Error() string {
@@ -0,0 +1,26 @@
+package errors
+
+import "strings"
+
+type GroupError struct {
+	Group  string
+	Errors []error
+}
+
+func NewGroupError(group string, errs []error) *GroupError {
+	return &GroupError{Group: group, Errors: errs}
+}
+
+func (c GroupError) Error() string {
+	builder := strings.Builder{}
+	builder.WriteString("并行组[")
+	builder.WriteString(c.Group)
+	builder.WriteString("]执行失败:")
+	for i, err := range c.Errors {
+		if i > 0 {
+			builder.WriteString("; ")
+		}
+		builder.WriteString(err.Error())
+	}
+	return builder.String()
+}
@@ -0,0 +1,18 @@
+package errors
+
+import "fmt"
+
+type RuleError struct {
+	Task string
+	Step int
+	Key  string
+	Expr string
+}
+
+func NewRuleError(step int, key string, expr string) *RuleError {
+	return &RuleError{Step: step, Key: key, Expr: expr}
+}
+
+func (c RuleError) Error() string {
+	return fmt.Sprintf("任务[%s]步骤[%d]必填项[%s]未提取到内容,表达式:%s", c.Task, c.Step, c.Key, c.Expr)
+}
@@ -0,0 +1,13 @@
+package errors
+
+type ScopeError struct {
+	msg string
+}
+
+func NewScopeError(msg string) *ScopeError {
+	return &ScopeError{msg: msg}
+}
+
+func (c ScopeError) Error() string {
+	return c.msg
+}
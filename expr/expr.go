@@ -0,0 +1,204 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/Knetic/govaluate"
+	"github.com/kaixinhupo/apiagent/util"
+	"regexp"
+	"strings"
+)
+
+// 未加方括号的路径变量,如 list.0.Title,需要转换为 [list.0.Title]
+var pathPattern = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[\w$]+)+`)
+
+// 字符串字面量替换成的参数名前缀,上下文中的路径不会以此开头
+const literalPrefix = "$literal"
+
+// 以上下文为参数的表达式求值,变量可以是以点分隔的路径
+// 表达式只能访问上下文和内置函数,不能访问文件、网络和进程
+func Eval(expression string, context *util.Context) (interface{}, error) {
+	prepared, literals := bracketPaths(expression)
+	evaluable, err := govaluate.NewEvaluableExpressionWithFunctions(prepared, functions(context))
+	if err != nil {
+		return nil, fmt.Errorf("表达式[%s]解析错误:%v", expression, err)
+	}
+	val, err := evaluable.Eval(parameters{context: context, literals: literals})
+	if err != nil {
+		return nil, fmt.Errorf("表达式[%s]求值错误:%v", expression, err)
+	}
+	return val, nil
+}
+
+// 表达式求值并转换为字符串
+func EvalString(expression string, context *util.Context) (string, error) {
+	val, err := Eval(expression, context)
+	if err != nil {
+		return "", err
+	}
+	return util.ToString(val), nil
+}
+
+// 表达式求值,结果必须为布尔值
+func EvalBool(expression string, context *util.Context) (bool, error) {
+	val, err := Eval(expression, context)
+	if err != nil {
+		return false, err
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("表达式[%s]的结果不是布尔值:%v", expression, val)
+	}
+	return b, nil
+}
+
+// 将字符串中的${表达式}替换为表达式的值
+func Interpolate(str string, context *util.Context) (string, error) {
+	if !strings.Contains(str, "${") {
+		return str, nil
+	}
+	builder := strings.Builder{}
+	rest := str
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			builder.WriteString(rest)
+			break
+		}
+		end := closingBrace(rest, start+2)
+		if end < 0 {
+			return "", fmt.Errorf("表达式未闭合:%s", str)
+		}
+		builder.WriteString(rest[:start])
+		val, err := EvalString(rest[start+2:end], context)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(val)
+		rest = rest[end+1:]
+	}
+	return builder.String(), nil
+}
+
+// 查找与${对应的},忽略字符串字面量中的括号
+func closingBrace(str string, from int) int {
+	depth := 0
+	var quote byte
+	for i := from; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// 为方括号以外的路径变量加上方括号,并将字符串字面量替换为参数
+// govaluate会把形如日期的字符串字面量转换为时间戳,替换后字面量按原样传给函数和运算符
+func bracketPaths(expression string) (string, map[string]interface{}) {
+	builder := strings.Builder{}
+	literals := make(map[string]interface{})
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == '\'' || c == '"':
+			literal := strings.Builder{}
+			end := i + 1
+			for end < len(expression) && expression[end] != c {
+				if expression[end] == '\\' && end+1 < len(expression) {
+					end++
+				}
+				literal.WriteByte(expression[end])
+				end++
+			}
+			if end >= len(expression) {
+				// 未闭合的字面量保留原样,由govaluate报告错误
+				builder.WriteString(expression[i:])
+				return builder.String(), literals
+			}
+			name := fmt.Sprintf("%s%d", literalPrefix, len(literals))
+			literals[name] = literal.String()
+			builder.WriteString("[" + name + "]")
+			i = end + 1
+		case c == '[':
+			end := strings.IndexByte(expression[i:], ']')
+			if end < 0 {
+				builder.WriteString(expression[i:])
+				return builder.String(), literals
+			}
+			builder.WriteString(expression[i : i+end+1])
+			i += end + 1
+		case isIdentStart(c) && (i == 0 || !isIdentPart(expression[i-1])):
+			if loc := pathPattern.FindStringIndex(expression[i:]); loc != nil {
+				builder.WriteByte('[')
+				builder.WriteString(expression[i : i+loc[1]])
+				builder.WriteByte(']')
+				i += loc[1]
+				continue
+			}
+			end := i + 1
+			for end < len(expression) && isIdentPart(expression[end]) {
+				end++
+			}
+			builder.WriteString(expression[i:end])
+			i = end
+		default:
+			builder.WriteByte(c)
+			i++
+		}
+	}
+	return builder.String(), literals
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// 以上下文作为表达式的参数
+type parameters struct {
+	context  *util.Context
+	literals map[string]interface{}
+}
+
+func (p parameters) Get(name string) (interface{}, error) {
+	if val, ok := p.literals[name]; ok {
+		return val, nil
+	}
+	val, ok := p.context.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("上下文中不存在:%s", name)
+	}
+	return normalize(val), nil
+}
+
+// 表达式中的数字统一为float64
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case float32:
+		return float64(v)
+	}
+	return val
+}
@@ -0,0 +1,108 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+func testContext() *util.Context {
+	context := util.NewContext()
+	context.Set("a", "x")
+	context.Set("n", 3)
+	context.Set("day", "2020-01-01")
+	context.Set("list", []map[string]interface{}{{"Title": "first"}})
+	return context
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       interface{}
+	}{
+		{"concat('2020-01-01', a)", "2020-01-01x"},
+		{"concat('a', \"b\", a)", "abx"},
+		{"day == '2020-01-01'", true},
+		{"day != '2020-01-02'", true},
+		{"'2020-01-01' == '2020-01-01 00:00:00'", false},
+		{"'it\\'s'", "it's"},
+		{"n + 1", float64(4)},
+		{"n > 2 && a == 'x'", true},
+		{"list.0.Title", "first"},
+		{"upper(list.0.Title)", "FIRST"},
+		{"substr('2020-01-01', 0, 4)", "2020"},
+		{"replace(day, '-', '')", "20200101"},
+		{"md5('')", "d41d8cd98f00b204e9800998ecf8427e"},
+		{"has('a') && !has('missing')", true},
+		{"get('missing', '2020-01-01')", "2020-01-01"},
+		{"len(day)", float64(10)},
+	}
+	for _, test := range tests {
+		got, err := Eval(test.expression, testContext())
+		if err != nil {
+			t.Errorf("%s: %v", test.expression, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s = %#v, want %#v", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	tests := []struct {
+		expression string
+		layout     string
+	}{
+		{"date()", "2006-01-02 15:04:05"},
+		{"date('2006-01-02 15:04:05')", "2006-01-02 15:04:05"},
+		{"date('2006-01-02')", "2006-01-02"},
+		{"date('20060102')", "20060102"},
+	}
+	for _, test := range tests {
+		got, err := EvalString(test.expression, testContext())
+		if err != nil {
+			t.Errorf("%s: %v", test.expression, err)
+			continue
+		}
+		if _, err := time.Parse(test.layout, got); err != nil {
+			t.Errorf("%s = %q, not in layout %s", test.expression, got, test.layout)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	tests := []struct {
+		str  string
+		want string
+	}{
+		{"plain", "plain"},
+		{"/day/${day}/${a}", "/day/2020-01-01/x"},
+		{"${concat('{', a, '}')}", "{x}"},
+		{"${day == '2020-01-01'}", "true"},
+	}
+	for _, test := range tests {
+		got, err := Interpolate(test.str, testContext())
+		if err != nil {
+			t.Errorf("%s: %v", test.str, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s = %q, want %q", test.str, got, test.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []string{
+		"missing + 1",
+		"concat('a'",
+		"'unclosed",
+	}
+	for _, expression := range tests {
+		if _, err := Eval(expression, testContext()); err == nil {
+			t.Errorf("%s: expected error", expression)
+		}
+	}
+}
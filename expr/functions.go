@@ -0,0 +1,276 @@
+package expr
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/Knetic/govaluate"
+	"github.com/kaixinhupo/apiagent/util"
+	"hash"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 内置函数,has和get需要访问上下文
+func functions(context *util.Context) map[string]govaluate.ExpressionFunction {
+	return map[string]govaluate.ExpressionFunction{
+		"now":           now,
+		"now_ms":        nowMs,
+		"date":          date,
+		"md5":           digest(md5.New),
+		"sha1":          digest(sha1.New),
+		"sha256":        digest(sha256.New),
+		"hmac_md5":      hmacDigest(md5.New),
+		"hmac_sha1":     hmacDigest(sha1.New),
+		"hmac_sha256":   hmacDigest(sha256.New),
+		"base64":        base64Encode,
+		"base64_decode": base64Decode,
+		"urlencode":     urlEncode,
+		"concat":        concat,
+		"upper":         stringFunc(strings.ToUpper),
+		"lower":         stringFunc(strings.ToLower),
+		"trim":          stringFunc(strings.TrimSpace),
+		"replace":       replace,
+		"substr":        substr,
+		"len":           length,
+		"str":           str,
+		"num":           num,
+		"int":           toInt,
+		"join":          join,
+		"has": func(args ...interface{}) (interface{}, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("has需要1个参数")
+			}
+			_, ok := context.Get(util.ToString(args[0]))
+			return ok, nil
+		},
+		"get": func(args ...interface{}) (interface{}, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return nil, fmt.Errorf("get需要1到2个参数")
+			}
+			val, ok := context.Get(util.ToString(args[0]))
+			if !ok {
+				if len(args) == 2 {
+					return args[1], nil
+				}
+				return nil, nil
+			}
+			return normalize(val), nil
+		},
+	}
+}
+
+// 当前Unix时间戳,单位秒
+func now(args ...interface{}) (interface{}, error) {
+	return float64(time.Now().Unix()), nil
+}
+
+// 当前Unix时间戳,单位毫秒
+func nowMs(args ...interface{}) (interface{}, error) {
+	return float64(time.Now().UnixNano() / int64(time.Millisecond)), nil
+}
+
+// 按Go时间格式输出当前时间,如 date('2006-01-02 15:04:05')
+func date(args ...interface{}) (interface{}, error) {
+	layout := "2006-01-02 15:04:05"
+	if len(args) > 0 {
+		layout = util.ToString(args[0])
+	}
+	return time.Now().Format(layout), nil
+}
+
+// 摘要函数,第二个参数为base64时输出base64,否则输出小写十六进制
+func digest(newHash func() hash.Hash) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, fmt.Errorf("摘要函数需要1到2个参数")
+		}
+		h := newHash()
+		h.Write([]byte(util.ToString(args[0])))
+		return encodeSum(h.Sum(nil), args[1:]), nil
+	}
+}
+
+// HMAC函数,参数为密钥、数据和可选的输出格式
+func hmacDigest(newHash func() hash.Hash) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("HMAC函数需要2到3个参数")
+		}
+		h := hmac.New(newHash, []byte(util.ToString(args[0])))
+		h.Write([]byte(util.ToString(args[1])))
+		return encodeSum(h.Sum(nil), args[2:]), nil
+	}
+}
+
+func encodeSum(sum []byte, format []interface{}) string {
+	if len(format) > 0 && util.ToString(format[0]) == "base64" {
+		return base64.StdEncoding.EncodeToString(sum)
+	}
+	return hex.EncodeToString(sum)
+}
+
+func base64Encode(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("base64需要1个参数")
+	}
+	return base64.StdEncoding.EncodeToString([]byte(util.ToString(args[0]))), nil
+}
+
+func base64Decode(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("base64_decode需要1个参数")
+	}
+	data, err := base64.StdEncoding.DecodeString(util.ToString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func urlEncode(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("urlencode需要1个参数")
+	}
+	return url.QueryEscape(util.ToString(args[0])), nil
+}
+
+func concat(args ...interface{}) (interface{}, error) {
+	builder := strings.Builder{}
+	for _, arg := range args {
+		builder.WriteString(util.ToString(arg))
+	}
+	return builder.String(), nil
+}
+
+func stringFunc(fn func(string) string) govaluate.ExpressionFunction {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("需要1个参数")
+		}
+		return fn(util.ToString(args[0])), nil
+	}
+}
+
+func replace(args ...interface{}) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("replace需要3个参数")
+	}
+	return strings.ReplaceAll(util.ToString(args[0]), util.ToString(args[1]), util.ToString(args[2])), nil
+}
+
+// 按字符截取,substr(s, start[, length])
+func substr(args ...interface{}) (interface{}, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, fmt.Errorf("substr需要2到3个参数")
+	}
+	runes := []rune(util.ToString(args[0]))
+	start, err := intArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > len(runes) {
+		start = len(runes)
+	}
+	end := len(runes)
+	if len(args) == 3 {
+		n, err := intArg(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if start+n < end {
+			end = start + n
+		}
+	}
+	if end < start {
+		end = start
+	}
+	return string(runes[start:end]), nil
+}
+
+// 字符串的字符数,或集合的元素数量
+func length(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("len需要1个参数")
+	}
+	switch v := args[0].(type) {
+	case nil:
+		return 0.0, nil
+	case string:
+		return float64(len([]rune(v))), nil
+	case []interface{}:
+		return float64(len(v)), nil
+	case []map[string]interface{}:
+		return float64(len(v)), nil
+	case []string:
+		return float64(len(v)), nil
+	case map[string]interface{}:
+		return float64(len(v)), nil
+	}
+	return float64(len([]rune(util.ToString(args[0])))), nil
+}
+
+func str(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("str需要1个参数")
+	}
+	return util.ToString(args[0]), nil
+}
+
+func num(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("num需要1个参数")
+	}
+	if f, ok := args[0].(float64); ok {
+		return f, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(util.ToString(args[0])), 64)
+	if err != nil {
+		return nil, fmt.Errorf("无法转换为数字:%v", args[0])
+	}
+	return f, nil
+}
+
+func toInt(args ...interface{}) (interface{}, error) {
+	f, err := num(args...)
+	if err != nil {
+		return nil, err
+	}
+	return math.Trunc(f.(float64)), nil
+}
+
+func join(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("join需要2个参数")
+	}
+	var parts []string
+	switch v := args[0].(type) {
+	case []interface{}:
+		for _, item := range v {
+			parts = append(parts, util.ToString(item))
+		}
+	case []string:
+		parts = v
+	default:
+		return nil, fmt.Errorf("join的第一个参数不是数组")
+	}
+	return strings.Join(parts, util.ToString(args[1])), nil
+}
+
+func intArg(arg interface{}) (int, error) {
+	f, err := num(arg)
+	if err != nil {
+		return 0, err
+	}
+	return int(f.(float64)), nil
+}
@@ -1,10 +1,18 @@
 module github.com/kaixinhupo/apiagent
 
-go 1.13
+go 1.16
 
 require (
 	github.com/Jeffail/gabs/v2 v2.1.0
+	github.com/Knetic/govaluate v3.0.0+incompatible
 	github.com/PuerkitoBio/goquery v1.5.0
+	github.com/antchfx/xmlquery v1.3.5
+	github.com/antchfx/xpath v1.2.4
 	github.com/axgle/mahonia v0.0.0-20180208002826-3358181d7394
+	github.com/golang-jwt/jwt/v4 v4.5.0
 	github.com/hoisie/mustache v0.0.0-20160804235033-6375acf62c69
+	github.com/robertkrimen/otto v0.0.0-20200922221731-ef014fd054ac
+	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc
+	gopkg.in/sourcemap.v1 v1.0.5 // indirect
 )
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"fmt"
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/expr"
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+// 调用其他任务,按Inputs传入参数,按Outputs映射结果
+// 同一站点的任务在当前会话中执行,其他站点的任务使用该站点的账号池执行
+func (h *HttpClient) runCall(caller *cfg.Task, s *cfg.Step, context *util.Context) (map[string]interface{}, error) {
+	call := s.Call
+	config, _ := cfg.DefaultConfig()
+	task := config.ResolveTask(caller.Site(), call.Task)
+	if task == nil {
+		return nil, fmt.Errorf("调用的任务[%s]不存在", call.Task)
+	}
+	inputs := make(map[string]interface{}, len(call.Inputs))
+	for _, p := range call.Inputs {
+		val, err := paramData(p, context)
+		if err != nil {
+			return nil, err
+		}
+		inputs[p.Key] = val
+	}
+	var rst map[string]interface{}
+	var err error
+	if task.Site() == caller.Site() {
+		rst, err = h.RunTaskContext(context.Done(), task, inputs, context.Report)
+	} else {
+		rst, err = RunTaskContext(context.Done(), task, inputs, context.Report)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("调用任务[%s]失败:%w", call.Task, err)
+	}
+	if len(call.Outputs) == 0 {
+		return rst, nil
+	}
+	body := make(map[string]interface{}, len(call.Outputs))
+	for from, to := range call.Outputs {
+		if v, ok := rst[from]; ok {
+			body[to] = v
+		}
+	}
+	return body, nil
+}
+
+// 参数的值,设置Expr时为表达式的值,非常量时按路径从上下文中取值,转换为字符串
+func paramValue(p *cfg.Param, context *util.Context) (string, error) {
+	val, err := paramData(p, context)
+	if err != nil {
+		return "", err
+	}
+	return util.ToString(val), nil
+}
+
+// 参数的原始值,保留上下文中的数字、对象和数组
+func paramData(p *cfg.Param, context *util.Context) (interface{}, error) {
+	if p.Expr != "" {
+		return expr.Eval(p.Expr, context)
+	}
+	if p.IsConst {
+		return p.Value, nil
+	}
+	val, _ := context.Get(p.Value)
+	return val, nil
+}
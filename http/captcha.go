@@ -0,0 +1,65 @@
+package http
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/kaixinhupo/apiagent/captcha"
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/expr"
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+// 使用当前会话下载验证码图片并识别,识别结果写入Key
+func (h *HttpClient) runCaptcha(s *cfg.Step, context *util.Context) (map[string]interface{}, error) {
+	c := s.Captcha
+	solver, err := captcha.NewSolver(c)
+	if err != nil {
+		return nil, err
+	}
+	urlStr, err := expr.Interpolate(c.Url, context)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL, err = siteUrl(h.Site, req.URL); err != nil {
+		return nil, err
+	}
+	req.Host = req.URL.Host
+	if h.Site != nil {
+		for k, v := range h.Site.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	log.Println("下载验证码:", req.URL)
+	resp, err := h.Do(req.WithContext(context.Done()))
+	if err != nil {
+		return nil, err
+	}
+	image, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载验证码失败,状态码%d", resp.StatusCode)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(image)
+	}
+	answer, err := solver.Solve(context.Done(), image, contentType)
+	if err != nil {
+		return nil, fmt.Errorf("验证码识别失败:%w", err)
+	}
+	key := c.Key
+	if key == "" {
+		key = "captcha"
+	}
+	return map[string]interface{}{key: answer}, nil
+}
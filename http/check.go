@@ -0,0 +1,158 @@
+package http
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"github.com/kaixinhupo/apiagent/expr"
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+const WarningsKey = "_warnings"
+
+// 未通过且配置为警告的断言
+type Warning struct {
+	Step    int    //步骤序号
+	Key     string //断言的键
+	Code    string //错误码
+	Message string //错误信息
+}
+
+// 校验步骤结果,失败的断言返回CheckError,配置为警告的断言记录在返回的警告中
+func checkBody(s *cfg.Step, body map[string]interface{}, context *util.Context) ([]*Warning, error) {
+	if s.Output == nil {
+		return nil, nil
+	}
+	if s.Output.Check != nil {
+		if err := checkParam(s.Output.Check, body, context); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.Output.Checks) == 0 {
+		return nil, nil
+	}
+	data := util.NewContext()
+	util.CopyMap(body, data.Data())
+	var warnings []*Warning
+	for _, a := range s.Output.Checks {
+		ok, err := assert(a, data, context)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			continue
+		}
+		message := a.Message
+		if message == "" {
+			message = fmt.Sprintf("断言[%s %s]不通过", a.Key, opName(a.Op))
+		}
+		if !a.Warn {
+			return nil, errors2.NewCheckErrorWithCode(a.Code, message)
+		}
+		code := a.Code
+		if code == "" {
+			code = errors2.DefaultCheckCode
+		}
+		warnings = append(warnings, &Warning{Step: s.Sort, Key: a.Key, Code: code, Message: message})
+	}
+	return warnings, nil
+}
+
+// 兼容单个校验规则,结果中Key的值需等于常量、上下文中的值或表达式的值
+func checkParam(check *cfg.Param, body map[string]interface{}, context *util.Context) error {
+	var chkVal string
+	if check.Expr != "" {
+		val, err := expr.EvalString(check.Expr, context)
+		if err != nil {
+			return err
+		}
+		chkVal = val
+	} else if !check.IsConst {
+		if _v, ok := context.GetString(check.Key); ok {
+			chkVal = _v
+		}
+	} else {
+		chkVal = check.Value
+	}
+
+	if v, ok := body[check.Key]; !ok || util.ToString(v) != chkVal {
+		return errors2.NewCheckError("校验不通过")
+	}
+	return nil
+}
+
+func assert(a *cfg.Assertion, data *util.Context, context *util.Context) (bool, error) {
+	expected := a.Value
+	if a.Expr != "" {
+		val, err := expr.EvalString(a.Expr, context)
+		if err != nil {
+			return false, err
+		}
+		expected = val
+	}
+	raw, exists := data.Get(a.Key)
+	val := util.ToString(raw)
+	switch a.Op {
+	case cfg.OP_EQ, "":
+		return exists && val == expected, nil
+	case cfg.OP_NE:
+		return !exists || val != expected, nil
+	case cfg.OP_EXISTS:
+		return exists, nil
+	case cfg.OP_REGEX:
+		reg, err := regexp.Compile(expected)
+		if err != nil {
+			return false, err
+		}
+		return exists && reg.MatchString(val), nil
+	case cfg.OP_NOT_EMPTY:
+		return exists && length(raw) > 0, nil
+	case cfg.OP_MIN_LEN:
+		min, err := strconv.Atoi(expected)
+		if err != nil {
+			return false, fmt.Errorf("断言minlen的比较值不是整数:%s", expected)
+		}
+		return exists && length(raw) >= min, nil
+	case cfg.OP_RANGE:
+		if a.Min == nil && a.Max == nil {
+			return false, fmt.Errorf("断言range至少需要设置Min或Max")
+		}
+		num, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if !exists || err != nil {
+			return false, nil
+		}
+		return (a.Min == nil || num >= *a.Min) && (a.Max == nil || num <= *a.Max), nil
+	}
+	return false, fmt.Errorf("不支持的断言:%s", a.Op)
+}
+
+// 集合的元素数量或字符串的字符数
+func length(v interface{}) int {
+	switch val := v.(type) {
+	case nil:
+		return 0
+	case []map[string]interface{}:
+		return len(val)
+	case []interface{}:
+		return len(val)
+	case []string:
+		return len(val)
+	case map[string]interface{}:
+		return len(val)
+	case string:
+		return utf8.RuneCountInString(strings.TrimSpace(val))
+	}
+	return utf8.RuneCountInString(util.ToString(v))
+}
+
+func opName(op string) string {
+	if op == "" {
+		return cfg.OP_EQ
+	}
+	return op
+}
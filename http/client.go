@@ -1,55 +1,82 @@
 package http
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/Jeffail/gabs/v2"
 	"github.com/axgle/mahonia"
 	"github.com/hoisie/mustache"
 	cfg "github.com/kaixinhupo/apiagent/config"
 	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"github.com/kaixinhupo/apiagent/expr"
 	"github.com/kaixinhupo/apiagent/parser"
+	"github.com/kaixinhupo/apiagent/util"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/cookiejar"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 )
 
 type HttpClient struct {
 	http.Client
-	IsLogin bool
+	IsLogin   bool
+	Site      *cfg.Site           //所属站点
+	Arguments []*cfg.KeyValuePair //账号参数,覆盖预设参数
 }
 
-var defaultClient *HttpClient = nil
+// 单个任务中执行步骤的次数上限,防止goto形成死循环
+const maxStepRuns = 1000
 
-var clientMutex = new(sync.Mutex)
-
-func DefaultClient() *HttpClient {
-	if defaultClient == nil {
-		clientMutex.Lock()
-		if defaultClient == nil {
-			defaultClient = &HttpClient{IsLogin: true}
-		}
-		clientMutex.Unlock()
+// 创建站点的客户端,使用独立的Cookie和站点的传输设置
+func NewHttpClient(site *cfg.Site, arguments []*cfg.KeyValuePair) *HttpClient {
+	jar, _ := cookiejar.New(nil)
+	client := &HttpClient{Site: site, Arguments: arguments}
+	client.Jar = jar
+	transport, err := transportOf(site)
+	if err != nil {
+		log.Println("站点传输设置错误,使用默认设置:", err)
+	} else {
+		client.Transport = transport
+	}
+	if site != nil && site.Transport != nil && site.Transport.Timeout > 0 {
+		client.Timeout = time.Duration(site.Transport.Timeout) * time.Second
 	}
-	return defaultClient
+	return client
 }
 
-func (h *HttpClient) Login() {
-	if h.IsLogin {
-		return
-	}
+// 执行站点的登录任务,失败时清除会话,未配置登录任务时视为已登录
+func (h *HttpClient) Login() error {
 	config, _ := cfg.DefaultConfig()
-	task := config.GetTaskByName("login")
-	_, err := h.RunTask(task)
+	site := h.Site
+	if site == nil {
+		site = config.DefaultSite()
+	}
+	task := site.GetLoginTask()
+	if task == nil {
+		h.IsLogin = true
+		return nil
+	}
+	_, err := h.RunTask(task, nil)
+	h.IsLogin = err == nil
 	if err != nil {
-		h.IsLogin = false
+		h.Jar, _ = cookiejar.New(nil)
 	}
+	return err
 }
 
-func (h *HttpClient) RunTask(task *cfg.Task) (map[string]interface{}, error) {
+// 执行任务,inputs中的参数覆盖预设参数
+func (h *HttpClient) RunTask(task *cfg.Task, inputs map[string]interface{}) (map[string]interface{}, error) {
+	return h.RunTaskContext(context.Background(), task, inputs, nil)
+}
+
+// 执行任务,ctx取消时停止执行,每个步骤开始时调用progress报告进度
+func (h *HttpClient) RunTaskContext(ctx context.Context, task *cfg.Task, inputs map[string]interface{}, progress func(event string)) (map[string]interface{}, error) {
 	if task == nil {
 		return nil, errors.New("Task does not exist")
 	}
@@ -57,72 +84,247 @@ func (h *HttpClient) RunTask(task *cfg.Task) (map[string]interface{}, error) {
 		return nil, errors.New("Task does not contain any steps")
 	}
 	result := make(map[string]interface{})
-	context := make(map[string]string)
+	context := util.NewContext()
+	context.SetControl(ctx, progress)
 
 	config, _ := cfg.DefaultConfig()
-	if config.Arguments != nil {
-		for _, p := range config.Arguments {
-			context[p.Key] = p.Value
+	for _, p := range config.Arguments {
+		context.Set(p.Key, p.Value)
+	}
+	if h.Site != nil {
+		for _, p := range h.Site.Arguments {
+			context.Set(p.Key, p.Value)
 		}
 	}
+	for _, p := range h.Arguments {
+		context.Set(p.Key, p.Value)
+	}
+	for k, v := range inputs {
+		context.Set(k, v)
+	}
 
-	for _, s := range task.Steps {
-		body, err := h.RunStep(s, &context)
+	_, err := h.runSteps(task, task.Steps, context, result)
+	if err != nil {
+		return nil, err
+	}
+	if len(task.Output) > 0 {
+		return shapeResult(task.Output, context, result)
+	}
+	if len(task.Export) > 0 {
+		return exportResult(task.Export, context, result), nil
+	}
+	return result, nil
+}
+
+// 按顺序执行步骤,处理执行条件、并行组和执行后的动作,遇到return动作时返回true
+func (h *HttpClient) runSteps(task *cfg.Task, steps []*cfg.Step, context *util.Context, result map[string]interface{}) (bool, error) {
+	runs := 0
+	for i := 0; i < len(steps); {
+		if err := context.Err(); err != nil {
+			return false, err
+		}
+		runs++
+		if runs > maxStepRuns {
+			return false, fmt.Errorf("任务[%s]执行步骤超过%d次,可能存在循环跳转", task.Name, maxStepRuns)
+		}
+		end := groupEnd(task, steps, i)
+		var executed []*cfg.Step
+		var err error
+		if end-i > 1 {
+			executed, err = h.runGroup(task, steps[i:end], context, result)
+		} else {
+			executed, err = h.runSingle(task, steps[i], context, result)
+		}
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 
-		if body != nil {
-			check := s.Output.Check
-			if check != nil {
-				var chkVal string
-				if !check.IsConst {
-					if _v, ok := context[check.Key]; ok {
-						chkVal = _v
-					}
-				} else {
-					chkVal = check.Value
-				}
-
-				if v, ok := body[check.Key]; ok {
-					if v != chkVal {
-						return nil, errors2.NewCheckError("校验不通过")
-					}
-				} else {
-					return nil, errors2.NewCheckError("校验不通过")
+		var action *cfg.Action
+		for _, s := range executed {
+			action, err = matchAction(s.Then, context)
+			if err != nil {
+				return false, err
+			}
+			if action != nil {
+				break
+			}
+		}
+		i = end
+		if action == nil {
+			continue
+		}
+		switch action.Do {
+		case cfg.ACTION_GOTO:
+			target := indexOfStep(steps, action.Target)
+			if target < 0 {
+				return false, fmt.Errorf("任务[%s]中不存在步骤:%s", task.Name, action.Target)
+			}
+			i = groupStart(task, steps, target)
+		case cfg.ACTION_SKIP:
+			count := 1
+			if action.Target != "" {
+				count, err = strconv.Atoi(action.Target)
+				if err != nil {
+					return false, fmt.Errorf("skip的步骤数量不是数字:%s", action.Target)
 				}
 			}
+			i += count
+		case cfg.ACTION_RETURN:
+			return true, nil
+		case cfg.ACTION_FAIL:
+			msg := action.Message
+			if msg == "" {
+				msg = "任务终止"
+			}
+			return false, errors2.NewCheckError(msg)
+		default:
+			return false, fmt.Errorf("不支持的动作:%s", action.Do)
+		}
+	}
+	return false, nil
+}
 
-			for k, v := range body {
-				if str, ok := v.(string); ok {
-					context[k] = str
-				}
-				result[k] = v
+// 满足执行条件时执行单个步骤,返回实际执行的步骤
+func (h *HttpClient) runSingle(task *cfg.Task, s *cfg.Step, context *util.Context, result map[string]interface{}) ([]*cfg.Step, error) {
+	ok, err := evalCondition(s.When, context)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		log.Println("不满足执行条件,跳过步骤:", s.Sort, s.Name)
+		return nil, nil
+	}
+	context.Report(fmt.Sprintf("%s步骤[%d %s]", task.FullName(), s.Sort, s.Name))
+	body, err := h.stepBody(task, s, context)
+	if err != nil {
+		return nil, err
+	}
+	err = mergeBody(s, body, context, result)
+	if err != nil {
+		return nil, err
+	}
+	return []*cfg.Step{s}, nil
+}
+
+// 执行单个步骤并返回步骤结果,循环步骤直接修改上下文中集合的元素,返回nil
+func (h *HttpClient) stepBody(task *cfg.Task, s *cfg.Step, context *util.Context) (map[string]interface{}, error) {
+	if s.ForEach != nil {
+		return nil, h.runLoop(task, s, context)
+	}
+	if s.Call != nil {
+		return h.runCall(task, s, context)
+	}
+	if s.Captcha != nil {
+		return h.runCaptcha(s, context)
+	}
+	var body map[string]interface{}
+	var err error
+	if s.Paging != nil {
+		body, err = h.runPagedStep(s, context)
+	} else {
+		body, err = h.RunStep(s, context)
+	}
+	if err != nil {
+		if ruleErr, ok := err.(*errors2.RuleError); ok {
+			ruleErr.Task = task.Name
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+// 校验步骤结果并合并到上下文和任务结果中
+func mergeBody(s *cfg.Step, body map[string]interface{}, context *util.Context, result map[string]interface{}) error {
+	if body == nil {
+		return nil
+	}
+	warnings, err := checkBody(s, body, context)
+	if err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		prev, _ := result[WarningsKey].([]*Warning)
+		result[WarningsKey] = append(prev, warnings...)
+	}
+
+	for k, v := range body {
+		if k == parser.DiagnosticsKey {
+			if prev, ok := result[k].([]*parser.Diagnostic); ok {
+				v = append(prev, v.([]*parser.Diagnostic)...)
 			}
+		} else {
+			context.Set(k, v)
 		}
+		result[k] = v
 	}
-	return result, nil
+	return nil
 }
 
-func (h *HttpClient) RunStep(step *cfg.Step, context *map[string]string) (map[string]interface{}, error) {
+// 返回第一个满足条件的动作
+func matchAction(actions []*cfg.Action, context *util.Context) (*cfg.Action, error) {
+	for _, a := range actions {
+		ok, err := evalCondition(a.When, context)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+func indexOfStep(steps []*cfg.Step, name string) int {
+	for i, s := range steps {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *HttpClient) RunStep(step *cfg.Step, context *util.Context) (map[string]interface{}, error) {
 	method := strings.ToLower(step.Input.Method)
 	if method == "" {
 		method = "get"
 	}
 	var req *http.Request
+	var err error
 	if method == "get" {
-		req = parseGet(step, context)
+		req, err = parseGet(step, context)
 	} else {
-		req = parsePost(step, context)
+		req, err = parsePost(step, context)
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	if req.URL, err = siteUrl(h.Site, req.URL); err != nil {
+		return nil, err
+	}
+	req.Host = req.URL.Host
+	if h.Site != nil {
+		for k, v := range h.Site.Headers {
+			req.Header.Set(k, v)
+		}
+	}
 	if step.Input.Headers != nil {
 		for k, v := range step.Input.Headers {
-			req.Header.Set(k, v)
+			val, err := expr.Interpolate(v, context)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set(k, val)
+		}
+	}
+	if step.Script != nil && step.Script.Before != "" {
+		req, err = runBeforeScript(step.Script, req, context)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	resp, err := h.Do(req)
+	resp, err := h.Do(req.WithContext(context.Done()))
 	if err != nil {
 		return nil, err
 	}
@@ -133,7 +335,11 @@ func (h *HttpClient) RunStep(step *cfg.Step, context *map[string]string) (map[st
 	if err != nil {
 		return nil, err
 	}
-	return parser.ParseStepResult(step, body)
+	result, err := parser.ParseStepResult(step, body)
+	if err != nil || step.Script == nil || step.Script.After == "" {
+		return result, err
+	}
+	return runAfterScript(step.Script, req, result, context)
 }
 
 func parseBody(data []byte, encoding string) (string, error) {
@@ -150,15 +356,20 @@ func parseBody(data []byte, encoding string) (string, error) {
 	return body, nil
 }
 
-func parsePost(step *cfg.Step, context *map[string]string) *http.Request {
-	urlStr := buildUrl(step, context)
+func parsePost(step *cfg.Step, context *util.Context) (*http.Request, error) {
+	urlStr, err := buildUrl(step, context)
+	if err != nil {
+		return nil, err
+	}
 	log.Println("url:", urlStr)
-	formBody := buildBody(step, context)
-	request, _ := http.NewRequest(http.MethodPost, urlStr, strings.NewReader(formBody))
-	return request
+	formBody, err := buildBody(step, context)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequest(http.MethodPost, urlStr, strings.NewReader(formBody))
 }
 
-func buildBody(step *cfg.Step, context *map[string]string) string {
+func buildBody(step *cfg.Step, context *util.Context) (string, error) {
 	templatePath := step.Input.TemplatePath
 	var mimeType string
 	if ct, ok := step.Input.Headers["Content-Type"]; ok {
@@ -178,70 +389,69 @@ func buildBody(step *cfg.Step, context *map[string]string) string {
 			if strings.Contains(mimeType, "json") {
 				json := gabs.New()
 				for _, v := range params {
-					var val string
-					if v.IsConst {
-						val = v.Value
-					} else {
-						if value, ok := (*context)[v.Value]; ok {
-							val = value
-						}
+					val, err := paramData(v, context)
+					if err != nil {
+						return "", err
 					}
 					_, _ = json.Set(val, v.Key)
 				}
-				return json.String()
+				return json.String(), nil
 			} else {
 				return buildFormStr(step.Input.Encoding, params, context)
 			}
 		}
 	}
-	return ""
+	return "", nil
 }
 
 func EncodeStr(input string, encoding string) string {
 	return input
 }
 
-func renderTemplate(path string, params []*cfg.Param, context *map[string]string) string {
+func renderTemplate(path string, params []*cfg.Param, context *util.Context) (string, error) {
 
 	appPath, _ := cfg.AppPath()
 	templatePath := filepath.Join(appPath, "config", "templates", path)
 	templateFile, err := os.Open(templatePath)
 	if err != nil {
 		log.Println("读取模板发生错误", err)
-		return ""
+		return "", nil
 	}
 	data, err := ioutil.ReadAll(templateFile)
 	_ = templateFile.Close()
-	_context := mergeContext(params, context)
-	return mustache.Render(string(data), _context)
+	_context, err := mergeContext(params, context)
+	if err != nil {
+		return "", err
+	}
+	return mustache.Render(string(data), _context), nil
 }
 
-func mergeContext(params []*cfg.Param, ctx *map[string]string) map[string]string {
-	context := *ctx
-	result := make(map[string]string, len(params)+len(context))
-	for k, v := range context {
-		result[k] = v
-	}
+func mergeContext(params []*cfg.Param, context *util.Context) (map[string]interface{}, error) {
+	data := context.Data()
+	result := make(map[string]interface{}, len(params)+len(data))
+	util.CopyMap(data, result)
 	for _, v := range params {
-		var val string
-		if v.IsConst {
-			val = v.Value
-		} else {
-			if value, ok := context[v.Value]; ok {
-				val = value
-			}
+		val, err := paramData(v, context)
+		if err != nil {
+			return nil, err
 		}
 		result[v.Key] = val
 	}
-	return result
+	return result, nil
 }
 
-func parseGet(step *cfg.Step, context *map[string]string) *http.Request {
-	urlStr := buildUrl(step, context)
+func parseGet(step *cfg.Step, context *util.Context) (*http.Request, error) {
+	urlStr, err := buildUrl(step, context)
+	if err != nil {
+		return nil, err
+	}
 	log.Println(urlStr)
 	params := step.Input.Params
 	if params != nil {
-		query := buildFormStr(step.Input.Encoding, params, context)
+		query, err := buildFormStr(step.Input.Encoding, params, context)
+		if err != nil {
+			return nil, err
+		}
 		if !strings.Contains(urlStr, "?") {
 			urlStr += "?"
 		}
@@ -250,36 +460,44 @@ func parseGet(step *cfg.Step, context *map[string]string) *http.Request {
 		}
 		urlStr = urlStr + query
 	}
-	request, _ := http.NewRequest(http.MethodGet, urlStr, nil)
-	return request
+	return http.NewRequest(http.MethodGet, urlStr, nil)
 }
 
-func buildFormStr(encoding string, params []*cfg.Param, context *map[string]string) string {
+func buildFormStr(encoding string, params []*cfg.Param, context *util.Context) (string, error) {
 	builder := strings.Builder{}
-	for _, v := range params {
-		var val string
-		if v.IsConst {
-			val = v.Value
-		} else {
-			if value, ok := (*context)[v.Value]; ok {
-				val = value
-			}
+	for i, v := range params {
+		if i > 0 {
+			builder.WriteByte('&')
+		}
+		val, err := paramValue(v, context)
+		if err != nil {
+			return "", err
 		}
 		builder.WriteString(EncodeStr(v.Key, encoding))
 		builder.WriteByte('=')
 		builder.WriteString(EncodeStr(val, encoding))
 	}
-	return builder.String()
+	return builder.String(), nil
 }
 
-func buildUrl(step *cfg.Step, context *map[string]string) string {
-	urlStr := step.Input.Url
+// 替换地址中的占位符,UrlParams的值为上下文路径,包含${表达式}时替换为表达式的值
+func buildUrl(step *cfg.Step, context *util.Context) (string, error) {
+	urlStr, err := expr.Interpolate(step.Input.Url, context)
+	if err != nil {
+		return "", err
+	}
 	if step.Input.UrlParams != nil {
 		for k, v := range step.Input.UrlParams {
-			if val, ok := (*context)[v]; ok {
+			if strings.Contains(v, "${") {
+				val, err := expr.Interpolate(v, context)
+				if err != nil {
+					return "", err
+				}
+				urlStr = strings.ReplaceAll(urlStr, k, val)
+			} else if val, ok := context.GetString(v); ok {
 				urlStr = strings.ReplaceAll(urlStr, k, val)
 			}
 		}
 	}
-	return urlStr
+	return urlStr, nil
 }
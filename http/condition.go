@@ -0,0 +1,85 @@
+package http
+
+import (
+	"fmt"
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/expr"
+	"github.com/kaixinhupo/apiagent/util"
+	"regexp"
+	"strconv"
+)
+
+// 根据上下文判断条件是否成立,条件为空时成立,Key可以是以点分隔的路径
+func evalCondition(c *cfg.Condition, context *util.Context) (bool, error) {
+	if c == nil {
+		return true, nil
+	}
+	if c.Expr != "" {
+		return expr.EvalBool(c.Expr, context)
+	}
+	switch c.Op {
+	case cfg.OP_AND:
+		for _, sub := range c.Conditions {
+			ok, err := evalCondition(sub, context)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case cfg.OP_OR:
+		for _, sub := range c.Conditions {
+			ok, err := evalCondition(sub, context)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case cfg.OP_NOT:
+		if len(c.Conditions) != 1 {
+			return false, fmt.Errorf("not条件需要一个子条件")
+		}
+		ok, err := evalCondition(c.Conditions[0], context)
+		return !ok, err
+	}
+
+	val, exists := context.GetString(c.Key)
+	switch c.Op {
+	case cfg.OP_EXISTS:
+		return exists, nil
+	case cfg.OP_EQ, "":
+		return exists && val == c.Value, nil
+	case cfg.OP_NE:
+		return !exists || val != c.Value, nil
+	case cfg.OP_REGEX:
+		reg, err := regexp.Compile(c.Value)
+		if err != nil {
+			return false, err
+		}
+		return exists && reg.MatchString(val), nil
+	case cfg.OP_GT, cfg.OP_GE, cfg.OP_LT, cfg.OP_LE:
+		expected, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return false, fmt.Errorf("条件%s的比较值不是数字:%s", c.Op, c.Value)
+		}
+		actual, err := strconv.ParseFloat(val, 64)
+		if !exists || err != nil {
+			return false, nil
+		}
+		return compareNumber(c.Op, actual, expected), nil
+	}
+	return false, fmt.Errorf("不支持的条件:%s", c.Op)
+}
+
+func compareNumber(op string, actual float64, expected float64) bool {
+	switch op {
+	case cfg.OP_GT:
+		return actual > expected
+	case cfg.OP_GE:
+		return actual >= expected
+	case cfg.OP_LT:
+		return actual < expected
+	case cfg.OP_LE:
+		return actual <= expected
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"fmt"
+	cfg "github.com/kaixinhupo/apiagent/config"
+	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"github.com/kaixinhupo/apiagent/util"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const ErrorsKey = "_errors"
+
+// 并行组中单个步骤的执行结果
+type groupOutcome struct {
+	index   int
+	body    map[string]interface{}
+	context *util.Context
+	err     error
+}
+
+// 步骤所属的并行组,未分组时返回空字符串
+func groupKey(task *cfg.Task, s *cfg.Step) string {
+	if s.Group != "" {
+		return s.Group
+	}
+	if task.ParallelSort {
+		return strconv.Itoa(s.Sort)
+	}
+	return ""
+}
+
+// 从start开始的连续同组步骤的结束位置(不包含)
+func groupEnd(task *cfg.Task, steps []*cfg.Step, start int) int {
+	key := groupKey(task, steps[start])
+	end := start + 1
+	if key == "" {
+		return end
+	}
+	for end < len(steps) && groupKey(task, steps[end]) == key {
+		end++
+	}
+	return end
+}
+
+// 步骤所在并行组的起始位置,goto到组内步骤时从组的开头执行
+func groupStart(task *cfg.Task, steps []*cfg.Step, index int) int {
+	key := groupKey(task, steps[index])
+	if key == "" {
+		return index
+	}
+	for index > 0 && groupKey(task, steps[index-1]) == key {
+		index--
+	}
+	return index
+}
+
+func groupPolicy(task *cfg.Task, key string) string {
+	for _, g := range task.Groups {
+		if g.Name == key && g.Policy != "" {
+			return g.Policy
+		}
+	}
+	return cfg.POLICY_FAIL_FAST
+}
+
+// 并发执行一组步骤,每个步骤使用上下文的副本,全部完成后按配置顺序合并结果
+// failfast:任意步骤失败时立即返回;collect:等待全部完成后返回所有错误;continue:错误记录在_errors中并继续执行
+func (h *HttpClient) runGroup(task *cfg.Task, steps []*cfg.Step, context *util.Context, result map[string]interface{}) ([]*cfg.Step, error) {
+	key := groupKey(task, steps[0])
+	policy := groupPolicy(task, key)
+	var running []*cfg.Step
+	for _, s := range steps {
+		ok, err := evalCondition(s.When, context)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			running = append(running, s)
+		} else {
+			log.Println("不满足执行条件,跳过步骤:", s.Sort, s.Name)
+		}
+	}
+	log.Println("并行执行步骤组:", key, " 数量:", len(running))
+
+	// 每个步骤使用上下文的深复制,失败时通过取消信号停止其他步骤
+	outcomes := make(chan *groupOutcome, len(running))
+	cancels := make([]func(), len(running))
+	for i, s := range running {
+		stepContext, cancel := context.Fork()
+		cancels[i] = cancel
+		go func(i int, s *cfg.Step) {
+			body, err := h.stepBody(task, s, stepContext)
+			outcomes <- &groupOutcome{index: i, body: body, context: stepContext, err: err}
+		}(i, s)
+	}
+	cancelAll := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+	defer cancelAll()
+	bodies := make([]map[string]interface{}, len(running))
+	errs := make([]error, len(running))
+	var firstErr error
+	for range running {
+		outcome := <-outcomes
+		if outcome.err != nil && policy == cfg.POLICY_FAIL_FAST && firstErr == nil {
+			firstErr = errors2.NewGroupError(key, []error{stepError(running[outcome.index], outcome.err)})
+			cancelAll()
+		}
+		bodies[outcome.index] = outcome.body
+		errs[outcome.index] = outcome.err
+		if s := running[outcome.index]; s.ForEach != nil && outcome.err == nil {
+			mergeLoop(s.ForEach, outcome.context, context, result)
+		}
+	}
+	// 等待其他步骤结束后再返回,避免取消后仍有请求在执行
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var failed []error
+	var executed []*cfg.Step
+	for i, s := range running {
+		err := errs[i]
+		if err == nil {
+			err = mergeBody(s, bodies[i], context, result)
+		}
+		if err != nil {
+			failed = append(failed, stepError(s, err))
+			continue
+		}
+		executed = append(executed, s)
+	}
+	if len(failed) == 0 {
+		return executed, nil
+	}
+	if policy == cfg.POLICY_CONTINUE {
+		messages, _ := result[ErrorsKey].([]string)
+		for _, err := range failed {
+			log.Println(err)
+			messages = append(messages, err.Error())
+		}
+		result[ErrorsKey] = messages
+		return executed, nil
+	}
+	return nil, errors2.NewGroupError(key, failed)
+}
+
+// 循环步骤修改的是上下文副本中的集合,结束后将集合写回上下文,任务结果中已有该集合时同时更新
+func mergeLoop(loop *cfg.Loop, from *util.Context, context *util.Context, result map[string]interface{}) {
+	key := strings.Split(loop.Collection, ".")[0]
+	val, ok := from.Get(key)
+	if !ok {
+		return
+	}
+	context.Set(key, val)
+	if _, ok := result[key]; ok {
+		result[key] = val
+	}
+}
+
+func stepError(s *cfg.Step, err error) error {
+	return fmt.Errorf("步骤[%d %s]:%w", s.Sort, s.Name, err)
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"fmt"
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/util"
+	"log"
+	"sync"
+)
+
+const defaultLoopItem = "item"
+
+// 对集合中的每个元素执行子步骤,元素的字段加入子步骤的上下文,子步骤的结果合并回元素
+// 每个元素使用上下文的深复制并发执行,全部结束后再合并结果,执行期间不修改共享的集合
+func (h *HttpClient) runLoop(task *cfg.Task, step *cfg.Step, context *util.Context) error {
+	loop := step.ForEach
+	val, exists := context.Get(loop.Collection)
+	if !exists {
+		log.Println("循环的集合不存在,跳过:", loop.Collection)
+		return nil
+	}
+	var items []interface{}
+	switch list := val.(type) {
+	case []map[string]interface{}:
+		items = make([]interface{}, len(list))
+		for i, v := range list {
+			items[i] = v
+		}
+	case []interface{}:
+		items = list
+	case []string:
+		items = make([]interface{}, len(list))
+		for i, v := range list {
+			items[i] = v
+		}
+	default:
+		return fmt.Errorf("任务[%s]中%s不是集合,无法循环", task.Name, loop.Collection)
+	}
+	parallel := loop.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var firstErr error
+	var cancels []func()
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	results := make([]map[string]interface{}, len(items))
+	sem := make(chan struct{}, parallel)
+	for i, item := range items {
+		mutex.Lock()
+		if firstErr == nil {
+			firstErr = context.Err()
+		}
+		failed := firstErr != nil
+		mutex.Unlock()
+		if failed {
+			break
+		}
+		sem <- struct{}{}
+		itemContext, cancel := context.Fork()
+		mutex.Lock()
+		cancels = append(cancels, cancel)
+		mutex.Unlock()
+		wg.Add(1)
+		go func(i int, item interface{}) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			itemResult, err := h.runLoopItem(task, loop, itemContext, item)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s第%d个元素执行失败:%w", loop.Collection, i+1, err)
+					// 失败时取消其他正在执行的元素
+					for _, cancel := range cancels {
+						cancel()
+					}
+				}
+				return
+			}
+			results[i] = itemResult
+		}(i, util.DeepCopy(item))
+	}
+	wg.Wait()
+	for i, item := range items {
+		if fields, ok := item.(map[string]interface{}); ok {
+			for k, v := range results[i] {
+				fields[k] = v
+			}
+		}
+	}
+	return firstErr
+}
+
+// 在元素自己的上下文中执行子步骤,返回子步骤的结果
+func (h *HttpClient) runLoopItem(task *cfg.Task, loop *cfg.Loop, itemContext *util.Context, item interface{}) (map[string]interface{}, error) {
+	itemKey := loop.Item
+	if itemKey == "" {
+		itemKey = defaultLoopItem
+	}
+	fields, _ := item.(map[string]interface{})
+	for k, v := range fields {
+		itemContext.Set(k, v)
+	}
+	itemContext.Set(itemKey, item)
+	itemResult := make(map[string]interface{})
+	if _, err := h.runSteps(task, loop.Steps, itemContext, itemResult); err != nil {
+		return nil, err
+	}
+	return itemResult, nil
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+// 并发处理的元素读取整个集合时不能与其他元素的结果合并发生竞争,需要以-race运行
+func TestRunLoopParallel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"detail":"detail-%s"}`, r.URL.Query().Get("id"))
+	}))
+	defer server.Close()
+
+	step := &cfg.Step{
+		ForEach: &cfg.Loop{
+			Collection: "list",
+			Parallel:   4,
+			Steps: []*cfg.Step{{
+				Input: &cfg.Req{
+					Url:     server.URL + "/?id=${item.id}",
+					Headers: map[string]string{"X-List": "${list}"},
+				},
+				Output: &cfg.Res{
+					Extract:   true,
+					ItemRules: []*cfg.ItemRule{{Type: cfg.TYPE_JSON, Expr: "detail", Key: "detail"}},
+				},
+			}},
+		},
+	}
+	task := &cfg.Task{Name: "loop", Steps: []*cfg.Step{step}}
+	list := make([]map[string]interface{}, 20)
+	for i := range list {
+		list[i] = map[string]interface{}{"id": fmt.Sprint(i)}
+	}
+	context2 := util.NewContext()
+	context2.SetControl(context.Background(), nil)
+	context2.Set("list", list)
+
+	client := NewHttpClient(nil, nil)
+	if _, err := client.runSteps(task, task.Steps, context2, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, item := range list {
+		if want := fmt.Sprintf("detail-%d", i); item["detail"] != want {
+			t.Fatalf("item %d detail = %v, want %s", i, item["detail"], want)
+		}
+	}
+}
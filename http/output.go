@@ -0,0 +1,86 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hoisie/mustache"
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/expr"
+	"github.com/kaixinhupo/apiagent/parser"
+	"github.com/kaixinhupo/apiagent/util"
+	"regexp"
+)
+
+// 按Export中的路径从上下文中选取任务结果,保留诊断和错误信息
+func exportResult(paths []string, context *util.Context, result map[string]interface{}) map[string]interface{} {
+	rst := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		if v, ok := context.Get(path); ok {
+			rst[path] = v
+		}
+	}
+	copyMeta(result, rst)
+	return rst
+}
+
+// 按Output定义生成任务结果,字段值来自上下文路径或以上下文渲染的模板
+func shapeResult(fields []*cfg.OutputField, context *util.Context, result map[string]interface{}) (map[string]interface{}, error) {
+	rst := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		val, err := fieldValue(f, context)
+		if err != nil {
+			return nil, err
+		}
+		util.SetPath(rst, f.Key, val)
+	}
+	copyMeta(result, rst)
+	return rst, nil
+}
+
+func fieldValue(f *cfg.OutputField, context *util.Context) (interface{}, error) {
+	if f.Expr != "" {
+		return expr.Eval(f.Expr, context)
+	}
+	if f.Template == "" {
+		val, _ := context.Get(f.From)
+		return val, nil
+	}
+	if !f.Json {
+		return mustache.Render(f.Template, context.Data()), nil
+	}
+	text, err := renderJson(f.Template, context)
+	if err != nil {
+		return nil, fmt.Errorf("结果字段[%s]的模板渲染失败:%v", f.Key, err)
+	}
+	var val interface{}
+	if err := json.Unmarshal([]byte(text), &val); err != nil {
+		return nil, fmt.Errorf("结果字段[%s]的模板渲染结果不是有效的JSON:%v", f.Key, err)
+	}
+	return val, nil
+}
+
+var jsonPlaceholder = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// 渲染JSON模板,{{path}}替换为上下文中该路径的值序列化后的JSON,不存在时为null
+// 字符串会加上引号并转义,模板中不需要再写引号
+func renderJson(template string, context *util.Context) (string, error) {
+	var firstErr error
+	text := jsonPlaceholder.ReplaceAllStringFunc(template, func(m string) string {
+		val, _ := context.Get(jsonPlaceholder.FindStringSubmatch(m)[1])
+		data, err := json.Marshal(val)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return string(data)
+	})
+	return text, firstErr
+}
+
+// 保留诊断、错误和警告信息
+func copyMeta(src map[string]interface{}, target map[string]interface{}) {
+	for _, key := range []string{parser.DiagnosticsKey, ErrorsKey, WarningsKey} {
+		if v, ok := src[key]; ok {
+			target[key] = v
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package http
+
+import (
+	"testing"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+func TestShapeResultJson(t *testing.T) {
+	context := util.NewContext()
+	context.Set("title", `say "hi" & <bye>`)
+	context.Set("count", 3)
+	fields := []*cfg.OutputField{
+		{Key: "item", Template: `{"title":{{title}},"count":{{count}},"missing":{{none}}}`, Json: true},
+		{Key: "text", Template: "{{count}} items"},
+	}
+	rst, err := shapeResult(fields, context, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := rst["item"].(map[string]interface{})
+	if item["title"] != `say "hi" & <bye>` || item["count"] != float64(3) || item["missing"] != nil {
+		t.Fatalf("item = %v", item)
+	}
+	if rst["text"] != "3 items" {
+		t.Fatalf("text = %v", rst["text"])
+	}
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/util"
+	"log"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultMaxPages     = 100
+	defaultPageCountKey = "pageCount"
+)
+
+// 执行分页步骤,逐页请求并拼接Paging.Collection指定的集合
+// 集合为空、内容与之前的页重复、下一页地址为空或重复、达到最大页数时停止
+func (h *HttpClient) runPagedStep(step *cfg.Step, context *util.Context) (map[string]interface{}, error) {
+	paging := step.Paging
+	maxPages := paging.MaxPages
+	if maxPages <= 0 {
+		maxPages = defaultMaxPages
+	}
+	increment := paging.Increment
+	if increment == 0 {
+		increment = 1
+	}
+	countKey := paging.PageCountKey
+	if countKey == "" {
+		countKey = defaultPageCountKey
+	}
+
+	var result map[string]interface{}
+	items := make([]map[string]interface{}, 0)
+	seenContent := make(map[string]bool)
+	seenUrl := make(map[string]bool)
+	current := step
+	page := paging.Start
+	count := 0
+	for count < maxPages {
+		if err := context.Err(); err != nil {
+			return nil, err
+		}
+		if paging.Mode == cfg.PAGING_PARAM {
+			context.Set(paging.Param, strconv.Itoa(page))
+		}
+		currentUrl, err := buildUrl(current, context)
+		if err != nil {
+			return nil, err
+		}
+		seenUrl[currentUrl] = true
+		body, err := h.RunStep(current, context)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = body
+		}
+		pageItems, _ := body[paging.Collection].([]map[string]interface{})
+		if len(pageItems) == 0 {
+			log.Println("第", count+1, "页没有数据,停止翻页")
+			break
+		}
+		digest := contentDigest(pageItems)
+		if seenContent[digest] {
+			log.Println("第", count+1, "页内容重复,停止翻页")
+			break
+		}
+		seenContent[digest] = true
+		items = append(items, pageItems...)
+		count++
+
+		if paging.Mode == cfg.PAGING_NEXT {
+			next, _ := body[paging.NextKey].(string)
+			if next == "" {
+				break
+			}
+			nextUrl, err := resolveUrl(currentUrl, next)
+			if err != nil {
+				log.Println("解析下一页地址发生错误:", err)
+				break
+			}
+			if seenUrl[nextUrl] {
+				break
+			}
+			current = nextPageStep(step, nextUrl)
+		} else {
+			page += increment
+		}
+	}
+	if result == nil {
+		result = make(map[string]interface{})
+	}
+	if paging.NextKey != "" {
+		delete(result, paging.NextKey)
+	}
+	result[paging.Collection] = items
+	result[countKey] = count
+	return result, nil
+}
+
+// 下一页地址已包含查询参数,以GET方式直接请求
+func nextPageStep(step *cfg.Step, nextUrl string) *cfg.Step {
+	input := *step.Input
+	input.Url = nextUrl
+	input.Method = "get"
+	input.UrlParams = nil
+	input.Params = nil
+	input.TemplatePath = ""
+	next := *step
+	next.Input = &input
+	return &next
+}
+
+func resolveUrl(base string, ref string) (string, error) {
+	baseUrl, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return baseUrl.ResolveReference(refUrl).String(), nil
+}
+
+func contentDigest(items []map[string]interface{}) string {
+	data, _ := json.Marshal(items)
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,325 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const (
+	defaultAccountName = "default"
+	defaultRetryAfter  = 60 * time.Second
+)
+
+var ErrNoAccount = errors.New("没有可用的账号")
+
+// 账号池中的账号
+type Account struct {
+	Name          string
+	Client        *HttpClient
+	loggedIn      bool
+	loggingIn     bool
+	inFlight      int
+	requests      int64
+	failures      int64
+	loginFailures int
+	evicted       bool
+	lastLogin     time.Time
+	lastError     string
+}
+
+// 账号的健康状态
+type AccountHealth struct {
+	Site          string    //站点名称,默认站点为空
+	Name          string    //账号名称
+	LoggedIn      bool      //是否已登录
+	Evicted       bool      //是否因登录失败被剔除
+	InFlight      int       //执行中的任务数
+	Requests      int64     //执行的任务总数
+	Failures      int64     //执行失败的任务数
+	LoginFailures int       //连续登录失败次数
+	LastLogin     time.Time //最近一次登录成功的时间
+	LastError     string    //最近一次错误
+}
+
+// 站点的账号池,按策略选择账号执行任务,登录失败的账号被剔除并定期重试
+type Pool struct {
+	lock       sync.Mutex
+	site       *cfg.Site
+	accounts   []*Account
+	strategy   string
+	retryAfter time.Duration
+	next       int
+}
+
+var (
+	defaultPools *Pools
+	poolsErr     error
+	poolsOnce    sync.Once
+)
+
+// 各站点的账号池
+type Pools struct {
+	pools []*Pool
+}
+
+// 按配置为每个站点创建的账号池,读取配置失败时返回错误
+func DefaultPools() (*Pools, error) {
+	poolsOnce.Do(func() {
+		config, err := cfg.DefaultConfig()
+		if err != nil {
+			poolsErr = err
+			return
+		}
+		defaultPools = NewPools(config)
+	})
+	return defaultPools, poolsErr
+}
+
+func NewPools(config *cfg.Config) *Pools {
+	sites := config.AllSites()
+	ps := &Pools{pools: make([]*Pool, len(sites))}
+	for i, site := range sites {
+		ps.pools[i] = NewPool(site)
+	}
+	return ps
+}
+
+// 站点的账号池
+func (ps *Pools) Get(site *cfg.Site) *Pool {
+	for _, p := range ps.pools {
+		if p.site == site {
+			return p
+		}
+	}
+	return nil
+}
+
+// 登录所有站点的账号,返回有可用账号的站点数
+func (ps *Pools) LoginAll() int {
+	ready := 0
+	for _, p := range ps.pools {
+		if p.LoginAll() > 0 {
+			ready++
+		}
+	}
+	return ready
+}
+
+// 站点总数
+func (ps *Pools) Len() int {
+	return len(ps.pools)
+}
+
+func (ps *Pools) Maintain(stop <-chan struct{}) {
+	for _, p := range ps.pools {
+		go p.Maintain(stop)
+	}
+}
+
+// 所有站点中账号的健康状态
+func (ps *Pools) Health() []*AccountHealth {
+	var list []*AccountHealth
+	for _, p := range ps.pools {
+		list = append(list, p.Health()...)
+	}
+	return list
+}
+
+// 使用任务所属站点的账号池执行任务
+func RunTask(task *cfg.Task, inputs map[string]interface{}) (map[string]interface{}, error) {
+	return RunTaskContext(context.Background(), task, inputs, nil)
+}
+
+// 使用任务所属站点的账号池执行任务,ctx取消时停止执行
+func RunTaskContext(ctx context.Context, task *cfg.Task, inputs map[string]interface{}, progress func(event string)) (map[string]interface{}, error) {
+	pools, err := DefaultPools()
+	if err != nil {
+		return nil, err
+	}
+	p := pools.Get(task.Site())
+	if p == nil {
+		return nil, ErrNoAccount
+	}
+	return p.RunTaskContext(ctx, task, inputs, progress)
+}
+
+func NewPool(site *cfg.Site) *Pool {
+	p := &Pool{site: site, strategy: cfg.STRATEGY_ROUND_ROBIN, retryAfter: defaultRetryAfter}
+	if s := site.Pool; s != nil {
+		if s.Strategy != "" {
+			p.strategy = s.Strategy
+		}
+		if s.RetryAfter > 0 {
+			p.retryAfter = time.Duration(s.RetryAfter) * time.Second
+		}
+	}
+	if len(site.Accounts) == 0 {
+		p.accounts = []*Account{{Name: defaultAccountName, Client: NewHttpClient(site, nil)}}
+		return p
+	}
+	for i, a := range site.Accounts {
+		name := a.Name
+		if name == "" {
+			name = fmt.Sprintf("account%d", i+1)
+		}
+		p.accounts = append(p.accounts, &Account{Name: name, Client: NewHttpClient(site, a.Arguments)})
+	}
+	return p
+}
+
+// 并发登录所有未登录的账号,返回可用账号数
+func (p *Pool) LoginAll() int {
+	var wg sync.WaitGroup
+	p.lock.Lock()
+	for _, a := range p.accounts {
+		if a.loggedIn || a.loggingIn {
+			continue
+		}
+		a.loggingIn = true
+		wg.Add(1)
+		go func(a *Account) {
+			defer wg.Done()
+			p.login(a)
+		}(a)
+	}
+	p.lock.Unlock()
+	wg.Wait()
+	return p.Available()
+}
+
+func (p *Pool) login(a *Account) {
+	err := a.Client.Login()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	a.loggingIn = false
+	a.loggedIn = err == nil
+	if err == nil {
+		log.Println("账号登录成功:", p.site.Name, a.Name)
+		a.loginFailures = 0
+		a.evicted = false
+		a.lastLogin = time.Now()
+		return
+	}
+	log.Println("账号登录失败:", p.site.Name, a.Name, err)
+	a.loginFailures++
+	a.lastError = err.Error()
+	if !a.evicted {
+		log.Println("剔除账号:", p.site.Name, a.Name)
+		a.evicted = true
+	}
+}
+
+// 定期重新登录被剔除的账号
+func (p *Pool) Maintain(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.retryAfter)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.LoginAll()
+		}
+	}
+}
+
+// 可用账号数
+func (p *Pool) Available() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	count := 0
+	for _, a := range p.accounts {
+		if a.usable() {
+			count++
+		}
+	}
+	return count
+}
+
+func (a *Account) usable() bool {
+	return a.loggedIn && !a.evicted
+}
+
+// 按策略选择账号,使用后需调用Release
+func (p *Pool) Acquire() (*Account, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var chosen *Account
+	for i := 0; i < len(p.accounts); i++ {
+		index := (p.next + i) % len(p.accounts)
+		a := p.accounts[index]
+		if !a.usable() {
+			continue
+		}
+		if chosen == nil || (p.strategy == cfg.STRATEGY_LEAST_LOADED && a.inFlight < chosen.inFlight) {
+			chosen = a
+			if p.strategy != cfg.STRATEGY_LEAST_LOADED {
+				break
+			}
+		}
+	}
+	if chosen == nil {
+		return nil, ErrNoAccount
+	}
+	for i, a := range p.accounts {
+		if a == chosen {
+			p.next = i + 1
+		}
+	}
+	chosen.inFlight++
+	chosen.requests++
+	return chosen, nil
+}
+
+func (p *Pool) Release(a *Account, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	a.inFlight--
+	if err != nil {
+		a.failures++
+		a.lastError = err.Error()
+	}
+}
+
+// 选择账号执行任务
+func (p *Pool) RunTask(task *cfg.Task, inputs map[string]interface{}) (map[string]interface{}, error) {
+	return p.RunTaskContext(context.Background(), task, inputs, nil)
+}
+
+func (p *Pool) RunTaskContext(ctx context.Context, task *cfg.Task, inputs map[string]interface{}, progress func(event string)) (map[string]interface{}, error) {
+	a, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	rst, err := a.Client.RunTaskContext(ctx, task, inputs, progress)
+	p.Release(a, err)
+	return rst, err
+}
+
+// 所有账号的健康状态
+func (p *Pool) Health() []*AccountHealth {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	list := make([]*AccountHealth, len(p.accounts))
+	for i, a := range p.accounts {
+		list[i] = &AccountHealth{
+			Site:          p.site.Name,
+			Name:          a.Name,
+			LoggedIn:      a.loggedIn,
+			Evicted:       a.evicted,
+			InFlight:      a.inFlight,
+			Requests:      a.requests,
+			Failures:      a.failures,
+			LoginFailures: a.loginFailures,
+			LastLogin:     a.lastLogin,
+			LastError:     a.lastError,
+		}
+	}
+	return list
+}
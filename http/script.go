@@ -0,0 +1,88 @@
+package http
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/script"
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+func scriptLimits(s *cfg.Script) script.Limits {
+	return script.Limits{
+		Timeout:   time.Duration(s.Timeout) * time.Millisecond,
+		MaxMemory: uint64(s.MaxMemory) << 20,
+	}
+}
+
+// 请求前执行脚本,按脚本修改后的request重建请求,ctx的修改写回上下文
+func runBeforeScript(s *cfg.Script, req *http.Request, context *util.Context) (*http.Request, error) {
+	var body string
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		body = string(data)
+	}
+	headers := make(map[string]interface{}, len(req.Header))
+	for k := range req.Header {
+		headers[k] = req.Header.Get(k)
+	}
+	request := map[string]interface{}{
+		"url":     req.URL.String(),
+		"method":  req.Method,
+		"headers": headers,
+		"body":    body,
+	}
+	globals := map[string]interface{}{"ctx": context.Data(), "request": request}
+	rst, err := script.Run(s.Before, globals, []string{"ctx", "request"}, scriptLimits(s))
+	if err != nil {
+		return nil, err
+	}
+	writeBack(rst["ctx"], context)
+
+	request, ok := rst["request"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("脚本中的request不是对象")
+	}
+	newReq, err := http.NewRequest(strings.ToUpper(util.ToString(request["method"])), util.ToString(request["url"]), strings.NewReader(util.ToString(request["body"])))
+	if err != nil {
+		return nil, err
+	}
+	if headers, ok := request["headers"].(map[string]interface{}); ok {
+		for k, v := range headers {
+			newReq.Header.Set(k, util.ToString(v))
+		}
+	}
+	return newReq, nil
+}
+
+// 解析后执行脚本,返回脚本修改或替换后的result
+func runAfterScript(s *cfg.Script, req *http.Request, result map[string]interface{}, context *util.Context) (map[string]interface{}, error) {
+	request := map[string]interface{}{"url": req.URL.String(), "method": req.Method}
+	globals := map[string]interface{}{"ctx": context.Data(), "request": request, "result": result}
+	rst, err := script.Run(s.After, globals, []string{"ctx", "result"}, scriptLimits(s))
+	if err != nil {
+		return nil, err
+	}
+	writeBack(rst["ctx"], context)
+	replaced, ok := rst["result"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("脚本中的result不是对象")
+	}
+	return replaced, nil
+}
+
+func writeBack(data interface{}, context *util.Context) {
+	if m, ok := data.(map[string]interface{}); ok {
+		for k, v := range m {
+			context.Set(k, v)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const (
+	defaultIdleTimeout = 30 * time.Minute
+	defaultMaxSessions = 1000
+)
+
+var (
+	ErrSessionExpired = errors.New("会话不存在或已过期")
+	ErrLoginFailed    = errors.New("登录失败")
+)
+
+// 调用方的上游会话
+type Session struct {
+	Owner    string
+	Site     string
+	Handle   string
+	Client   *HttpClient
+	Created  time.Time
+	LastUsed time.Time
+}
+
+// 会话信息
+type SessionInfo struct {
+	Owner    string    //调用方
+	Site     string    //站点名称,默认站点为空
+	Handle   string    //会话标识
+	Created  time.Time //创建时间
+	LastUsed time.Time //最近使用时间
+}
+
+// 按站点和调用方保存的上游会话,空闲超时后失效
+type SessionStore struct {
+	lock        sync.Mutex
+	sessions    map[string]*Session
+	idleTimeout time.Duration
+	maxSessions int
+}
+
+var (
+	defaultSessions *SessionStore
+	sessionsOnce    sync.Once
+)
+
+func DefaultSessions() *SessionStore {
+	sessionsOnce.Do(func() {
+		var policy *cfg.SessionPolicy
+		if config, err := cfg.DefaultConfig(); err == nil {
+			policy = config.Sessions
+		}
+		defaultSessions = NewSessionStore(policy)
+	})
+	return defaultSessions
+}
+
+func NewSessionStore(policy *cfg.SessionPolicy) *SessionStore {
+	s := &SessionStore{sessions: make(map[string]*Session), idleTimeout: defaultIdleTimeout, maxSessions: defaultMaxSessions}
+	if policy != nil {
+		if policy.IdleTimeout > 0 {
+			s.idleTimeout = time.Duration(policy.IdleTimeout) * time.Second
+		}
+		if policy.MaxSessions > 0 {
+			s.maxSessions = policy.MaxSessions
+		}
+	}
+	return s
+}
+
+// 在调用方的会话中执行任务,会话属于owner和任务所在的站点,不同调用方的会话相互隔离
+// handle对应的会话有效时直接使用;否则使用credentials登录并保存会话,会话标识由服务端生成
+func (s *SessionStore) RunTask(owner string, handle string, credentials map[string]string, task *cfg.Task, inputs map[string]interface{}) (map[string]interface{}, string, error) {
+	session := s.get(owner, task.Site(), handle)
+	if session == nil {
+		if len(credentials) == 0 {
+			return nil, "", ErrSessionExpired
+		}
+		var err error
+		session, err = s.login(owner, task.Site(), credentials)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	rst, err := session.Client.RunTask(task, inputs)
+	return rst, session.Handle, err
+}
+
+// 使用调用方的账号登录,成功后以新生成的标识保存会话
+func (s *SessionStore) login(owner string, site *cfg.Site, credentials map[string]string) (*Session, error) {
+	arguments := make([]*cfg.KeyValuePair, 0, len(credentials))
+	for k, v := range credentials {
+		arguments = append(arguments, &cfg.KeyValuePair{Key: k, Value: v})
+	}
+	client := NewHttpClient(site, arguments)
+	if err := client.Login(); err != nil {
+		return nil, fmt.Errorf("%w:%v", ErrLoginFailed, err)
+	}
+	handle := newHandle()
+	now := time.Now()
+	session := &Session{Owner: owner, Site: site.Name, Handle: handle, Client: client, Created: now, LastUsed: now}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire(now)
+	if len(s.sessions) >= s.maxSessions {
+		s.evictOldest()
+	}
+	s.sessions[sessionKey(owner, site.Name, handle)] = session
+	return session, nil
+}
+
+func (s *SessionStore) get(owner string, site *cfg.Site, handle string) *Session {
+	if handle == "" {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	key := sessionKey(owner, site.Name, handle)
+	session, ok := s.sessions[key]
+	if !ok || now.Sub(session.LastUsed) > s.idleTimeout {
+		delete(s.sessions, key)
+		return nil
+	}
+	session.LastUsed = now
+	return session
+}
+
+// 删除调用方在站点中的会话
+func (s *SessionStore) Remove(owner string, site string, handle string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := sessionKey(owner, site, handle)
+	_, ok := s.sessions[key]
+	delete(s.sessions, key)
+	return ok
+}
+
+// 未过期的会话列表,按最近使用时间倒序
+func (s *SessionStore) List() []*SessionInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expire(time.Now())
+	list := make([]*SessionInfo, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		list = append(list, &SessionInfo{Owner: session.Owner, Site: session.Site, Handle: session.Handle, Created: session.Created, LastUsed: session.LastUsed})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastUsed.After(list[j].LastUsed)
+	})
+	return list
+}
+
+// 定期清理过期会话
+func (s *SessionStore) Maintain(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.lock.Lock()
+			s.expire(now)
+			s.lock.Unlock()
+		}
+	}
+}
+
+func (s *SessionStore) expire(now time.Time) {
+	for key, session := range s.sessions {
+		if now.Sub(session.LastUsed) > s.idleTimeout {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+func (s *SessionStore) evictOldest() {
+	var oldest *Session
+	for _, session := range s.sessions {
+		if oldest == nil || session.LastUsed.Before(oldest.LastUsed) {
+			oldest = session
+		}
+	}
+	if oldest != nil {
+		delete(s.sessions, sessionKey(oldest.Owner, oldest.Site, oldest.Handle))
+	}
+}
+
+func sessionKey(owner string, site string, handle string) string {
+	return owner + "\n" + site + "\n" + handle
+}
+
+func newHandle() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"sync"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+var (
+	transportLock sync.Mutex
+	transports    = make(map[*cfg.Site]http.RoundTripper)
+)
+
+// 站点的传输设置,同一站点的客户端共享连接
+func transportOf(site *cfg.Site) (http.RoundTripper, error) {
+	if site == nil || site.Transport == nil {
+		return http.DefaultTransport, nil
+	}
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	if t, ok := transports[site]; ok {
+		return t, nil
+	}
+	setting := site.Transport
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if setting.Proxy != "" {
+		proxy, err := url.Parse(setting.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	if setting.InsecureSkipVerify {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if setting.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = setting.MaxIdleConnsPerHost
+	}
+	transports[site] = t
+	return t, nil
+}
+
+// 相对地址基于站点的基础地址解析
+func siteUrl(site *cfg.Site, u *url.URL) (*url.URL, error) {
+	if site == nil || site.BaseUrl == "" || u.IsAbs() {
+		return u, nil
+	}
+	base, err := url.Parse(site.BaseUrl)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(u), nil
+}
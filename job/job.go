@@ -0,0 +1,400 @@
+package job
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	http2 "github.com/kaixinhupo/apiagent/http"
+	"github.com/kaixinhupo/apiagent/webhook"
+)
+
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+
+	defaultWorkers   = 4
+	defaultQueueSize = 1000
+	defaultKeep      = 24 * time.Hour
+	cleanInterval    = time.Hour
+)
+
+var (
+	ErrQueueFull      = errors.New("任务队列已满")
+	ErrFinished       = errors.New("任务已结束")
+	ErrNotStarted     = errors.New("任务队列尚未启动")
+	ErrCallbackDenied = errors.New("回调地址未在调用方配置中登记")
+)
+
+// 异步任务
+type Job struct {
+	Id       string
+	Task     string                 //任务的完整名称
+	Inputs   map[string]interface{} //任务参数
+	Client   string                 //提交任务的调用方
+	Callback string                 //任务结束后通知的地址,必须是调用方配置的Callbacks之一
+	Status   string                 //queued,running,succeeded,failed,canceled
+	Progress *Progress              //执行进度,不保存到磁盘
+	Result   map[string]interface{}
+	Error    string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+}
+
+// 执行进度
+type Progress struct {
+	Steps   int    //已开始执行的步骤数,包括循环和调用中的步骤
+	Current string //当前步骤
+}
+
+func (j *Job) finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed || j.Status == StatusCanceled
+}
+
+// 异步任务队列,固定数量的工作者按提交顺序执行,任务状态保存在磁盘上,重启后继续执行未完成的任务
+type Manager struct {
+	lock      sync.Mutex
+	ready     *sync.Cond //队列中加入任务时通知工作者
+	jobs      map[string]*Job
+	cancels   map[string]context.CancelFunc
+	queue     []string        //等待执行的任务
+	recovered map[string]bool //队列中重启后恢复的任务,不占用提交的名额
+	submitted int             //队列中提交的任务数量
+	queueSize int
+	callbacks map[string][]*cfg.Webhook
+	started   bool
+	dir       string
+	keep      time.Duration
+	workers   int
+}
+
+// 按配置创建队列,加载磁盘上保存的任务,未完成的任务按提交顺序先加入队列
+// clients中的Callbacks为各调用方允许使用的回调地址
+func New(c *cfg.JobQueue, clients []*cfg.ClientPolicy) (*Manager, error) {
+	m := &Manager{jobs: make(map[string]*Job), cancels: make(map[string]context.CancelFunc), recovered: make(map[string]bool), callbacks: make(map[string][]*cfg.Webhook), workers: defaultWorkers, keep: defaultKeep}
+	m.ready = sync.NewCond(&m.lock)
+	for _, p := range clients {
+		m.callbacks[p.Client] = append(m.callbacks[p.Client], p.Callbacks...)
+	}
+	m.queueSize = defaultQueueSize
+	if c != nil {
+		if c.Workers > 0 {
+			m.workers = c.Workers
+		}
+		if c.QueueSize > 0 {
+			m.queueSize = c.QueueSize
+		}
+		if c.Keep > 0 {
+			m.keep = time.Duration(c.Keep) * time.Hour
+		}
+	}
+	dir := c.Path()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m.dir = dir
+	pending, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range pending {
+		m.queue = append(m.queue, job.Id)
+		m.recovered[job.Id] = true
+	}
+	return m, nil
+}
+
+// 读取保存的任务,返回按提交时间排序的未完成任务
+func (m *Manager) load() ([]*Job, error) {
+	files, err := filepath.Glob(filepath.Join(m.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Job
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			log.Println("任务文件格式错误,忽略:", file, err)
+			continue
+		}
+		m.jobs[job.Id] = job
+		if !job.finished() {
+			job.Status = StatusQueued
+			job.Started = time.Time{}
+			pending = append(pending, job)
+		}
+	}
+	sortByCreated(pending)
+	if len(pending) > 0 {
+		log.Println("恢复未完成的异步任务:", len(pending))
+	}
+	return pending, nil
+}
+
+func sortByCreated(jobs []*Job) {
+	for i := 1; i < len(jobs); i++ {
+		for j := i; j > 0 && jobs[j].Created.Before(jobs[j-1].Created); j-- {
+			jobs[j], jobs[j-1] = jobs[j-1], jobs[j]
+		}
+	}
+}
+
+// 启动工作者,启动前不接受新任务
+func (m *Manager) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.started {
+		return
+	}
+	m.started = true
+	for i := 0; i < m.workers; i++ {
+		go m.work()
+	}
+	go m.clean()
+}
+
+// 提交任务,队列未启动时返回ErrNotStarted,已满时返回ErrQueueFull,回调地址未登记时返回ErrCallbackDenied
+func (m *Manager) Submit(client string, task *cfg.Task, inputs map[string]interface{}, callback string) (*Job, error) {
+	if callback != "" && m.webhook(client, callback) == nil {
+		return nil, ErrCallbackDenied
+	}
+	job := &Job{Id: newId(), Task: task.FullName(), Inputs: inputs, Client: client, Callback: callback, Status: StatusQueued, Created: time.Now()}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.started {
+		return nil, ErrNotStarted
+	}
+	if m.submitted >= m.queueSize {
+		return nil, ErrQueueFull
+	}
+	m.queue = append(m.queue, job.Id)
+	m.submitted++
+	m.ready.Signal()
+	m.jobs[job.Id] = job
+	m.save(job)
+	copied := *job
+	return &copied, nil
+}
+
+// 取得任务的副本
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *job
+	if job.Progress != nil {
+		progress := *job.Progress
+		copied.Progress = &progress
+	}
+	return &copied, true
+}
+
+// 取消任务,排队中的任务直接结束,执行中的任务在当前请求或步骤结束后停止
+func (m *Manager) Cancel(id string) error {
+	m.lock.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.lock.Unlock()
+		return os.ErrNotExist
+	}
+	if job.finished() {
+		m.lock.Unlock()
+		return ErrFinished
+	}
+	if job.Status == StatusRunning {
+		m.cancels[id]()
+		m.lock.Unlock()
+		return nil
+	}
+	m.dequeue(id)
+	job.Status = StatusCanceled
+	job.Finished = time.Now()
+	m.save(job)
+	m.lock.Unlock()
+	m.callback(job)
+	return nil
+}
+
+func (m *Manager) work() {
+	for {
+		m.lock.Lock()
+		for len(m.queue) == 0 {
+			m.ready.Wait()
+		}
+		id := m.queue[0]
+		m.dequeue(id)
+		m.lock.Unlock()
+		m.run(id)
+	}
+}
+
+// 从队列中移除任务,释放提交的名额,需要持有锁
+func (m *Manager) dequeue(id string) {
+	for i, queued := range m.queue {
+		if queued == id {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+	if m.recovered[id] {
+		delete(m.recovered, id)
+	} else {
+		m.submitted--
+	}
+}
+
+func (m *Manager) run(id string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.lock.Lock()
+	job, ok := m.jobs[id]
+	if !ok || job.Status != StatusQueued {
+		m.lock.Unlock()
+		return
+	}
+	job.Status = StatusRunning
+	job.Started = time.Now()
+	job.Progress = &Progress{}
+	m.cancels[id] = cancel
+	m.save(job)
+	name, inputs := job.Task, job.Inputs
+	m.lock.Unlock()
+
+	log.Println("执行异步任务:", id, name)
+	var rst map[string]interface{}
+	config, err := cfg.DefaultConfig()
+	if err != nil {
+		log.Println("读取配置发生错误:", err)
+	} else if task := config.GetTaskByName(name); task == nil {
+		err = errors.New("任务未定义")
+	} else {
+		rst, err = http2.RunTaskContext(ctx, task, inputs, func(event string) {
+			m.lock.Lock()
+			job.Progress.Steps++
+			job.Progress.Current = event
+			m.lock.Unlock()
+		})
+	}
+
+	m.lock.Lock()
+	delete(m.cancels, id)
+	job.Finished = time.Now()
+	switch {
+	case ctx.Err() != nil:
+		job.Status = StatusCanceled
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	default:
+		job.Status = StatusSucceeded
+		job.Result = rst
+	}
+	m.save(job)
+	m.lock.Unlock()
+	log.Println("异步任务结束:", id, job.Status)
+	m.callback(job)
+}
+
+// 调用方配置中与地址一致的回调设置
+func (m *Manager) webhook(client string, url string) *cfg.Webhook {
+	for _, hook := range m.callbacks[client] {
+		if hook.Url == url {
+			return hook
+		}
+	}
+	return nil
+}
+
+// 任务结束后向回调地址发送任务信息,按回调配置的Secret签名
+func (m *Manager) callback(job *Job) {
+	if job.Callback == "" {
+		return
+	}
+	hook := m.webhook(job.Client, job.Callback)
+	if hook == nil {
+		log.Println("回调地址已不在调用方配置中,不发送通知:", job.Id, job.Callback)
+		return
+	}
+	m.lock.Lock()
+	data, err := json.Marshal(job)
+	m.lock.Unlock()
+	if err != nil {
+		log.Println("序列化任务发生错误:", err)
+		return
+	}
+	go webhook.Deliver(hook, data)
+}
+
+// 保存任务状态,需要持有锁
+func (m *Manager) save(job *Job) {
+	progress := job.Progress
+	job.Progress = nil
+	data, err := json.Marshal(job)
+	job.Progress = progress
+	if err != nil {
+		log.Println("序列化任务发生错误:", err)
+		return
+	}
+	tmp, err := ioutil.TempFile(m.dir, "tmp-*")
+	if err != nil {
+		log.Println("保存任务发生错误:", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	_ = tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.path(job.Id))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		log.Println("保存任务发生错误:", err)
+	}
+}
+
+func (m *Manager) path(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+// 定期删除超过保留时间的已结束任务
+func (m *Manager) clean() {
+	ticker := time.NewTicker(cleanInterval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		m.lock.Lock()
+		for id, job := range m.jobs {
+			if job.finished() && now.Sub(job.Finished) > m.keep {
+				delete(m.jobs, id)
+				_ = os.Remove(m.path(id))
+			}
+		}
+		m.lock.Unlock()
+		<-ticker.C
+	}
+}
+
+func newId() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,240 @@
+package job
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	http2 "github.com/kaixinhupo/apiagent/http"
+	"github.com/kaixinhupo/apiagent/webhook"
+)
+
+var upstream *httptest.Server
+
+// 任务按程序目录下的config/config.json读取,测试前写入只包含测试任务的配置
+func TestMain(m *testing.M) {
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		_, _ = w.Write([]byte("<html><title>ok</title></html>"))
+	}))
+	step := func(path string) string {
+		return `{"Sort":0,"Input":{"Url":"` + upstream.URL + path + `","Method":"get"},"Output":{"Extract":false}}`
+	}
+	config := `{"Port":1,"Tasks":[{"Name":"fast","Steps":[` + step("/fast") + `]},{"Name":"slow","Steps":[` + step("/slow") + `]}]}`
+	dir := filepath.Join(filepath.Dir(os.Args[0]), "config")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644); err != nil {
+		panic(err)
+	}
+	pools, err := http2.DefaultPools()
+	if err != nil {
+		panic(err)
+	}
+	pools.LoginAll()
+	code := m.Run()
+	upstream.Close()
+	os.Exit(code)
+}
+
+func task(t *testing.T, name string) *cfg.Task {
+	config, err := cfg.DefaultConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.GetTaskByName(name)
+}
+
+// 等待任务进入指定状态
+func wait(t *testing.T, m *Manager, id string, status string) *Job {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		j, ok := m.Get(id)
+		if ok && j.Status == status {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s: status = %v, want %s", id, j, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmitErrors(t *testing.T) {
+	clients := []*cfg.ClientPolicy{{Client: "demo", Callbacks: []*cfg.Webhook{{Url: "http://127.0.0.1:1/hook"}}}}
+	notStarted, err := New(&cfg.JobQueue{Dir: t.TempDir()}, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := New(&cfg.JobQueue{Dir: t.TempDir(), Workers: 1, QueueSize: 1}, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full.Start()
+	running, _ := full.Submit("demo", task(t, "slow"), nil, "")
+	wait(t, full, running.Id, StatusRunning)
+	queued, _ := full.Submit("demo", task(t, "fast"), nil, "")
+	defer func() {
+		_ = full.Cancel(queued.Id)
+		_ = full.Cancel(running.Id)
+	}()
+
+	tests := []struct {
+		name     string
+		m        *Manager
+		client   string
+		callback string
+		err      error
+	}{
+		{"not started", notStarted, "demo", "", ErrNotStarted},
+		{"queue full", full, "demo", "", ErrQueueFull},
+		{"unregistered callback", full, "demo", "http://169.254.169.254/", ErrCallbackDenied},
+		{"callback of other client", full, "other", "http://127.0.0.1:1/hook", ErrCallbackDenied},
+	}
+	for _, test := range tests {
+		if _, err := test.m.Submit(test.client, task(t, "fast"), nil, test.callback); err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	m, err := New(&cfg.JobQueue{Dir: t.TempDir(), Workers: 1, QueueSize: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	running, _ := m.Submit("demo", task(t, "slow"), nil, "")
+	wait(t, m, running.Id, StatusRunning)
+	queued, _ := m.Submit("demo", task(t, "fast"), nil, "")
+
+	if err := m.Cancel(queued.Id); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, m, queued.Id, StatusCanceled)
+	// 取消排队中的任务后释放名额
+	refilled, err := m.Submit("demo", task(t, "fast"), nil, "")
+	if err != nil {
+		t.Fatalf("submit after cancel: %v", err)
+	}
+	if err := m.Cancel(running.Id); err != nil {
+		t.Fatal(err)
+	}
+	j := wait(t, m, running.Id, StatusCanceled)
+	if j.Finished.Sub(j.Started) > 2*time.Second {
+		t.Errorf("running job was not interrupted: %v", j.Finished.Sub(j.Started))
+	}
+	if err := m.Cancel(running.Id); err != ErrFinished {
+		t.Errorf("cancel finished job: err = %v, want %v", err, ErrFinished)
+	}
+	if err := m.Cancel("missing"); err != os.ErrNotExist {
+		t.Errorf("cancel missing job: err = %v, want %v", err, os.ErrNotExist)
+	}
+	wait(t, m, refilled.Id, StatusSucceeded)
+	next, _ := m.Submit("demo", task(t, "fast"), nil, "")
+	if j := wait(t, m, next.Id, StatusSucceeded); j.Result["body"] == nil {
+		t.Errorf("result = %v", j.Result)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	saved := []*Job{
+		{Id: "second", Task: "fast", Status: StatusRunning, Created: now.Add(-time.Minute), Started: now},
+		{Id: "first", Task: "fast", Status: StatusQueued, Created: now.Add(-2 * time.Minute)},
+		{Id: "done", Task: "fast", Status: StatusSucceeded, Created: now.Add(-3 * time.Minute), Finished: now},
+		{Id: "unknown", Task: "missing", Status: StatusQueued, Created: now},
+	}
+	for _, j := range saved {
+		data, _ := json.Marshal(j)
+		if err := ioutil.WriteFile(filepath.Join(dir, j.Id+".json"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := New(&cfg.JobQueue{Dir: dir, Workers: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	// 启动后提交的任务排在恢复的任务之后
+	submitted, err := m.Submit("demo", task(t, "fast"), nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id     string
+		status string
+	}{
+		{"first", StatusSucceeded},
+		{"second", StatusSucceeded},
+		{"done", StatusSucceeded},
+		{"unknown", StatusFailed},
+		{submitted.Id, StatusSucceeded},
+	}
+	started := make(map[string]time.Time)
+	for _, test := range tests {
+		j := wait(t, m, test.id, test.status)
+		started[test.id] = j.Started
+	}
+	if !started["first"].Before(started["second"]) || !started["unknown"].Before(started[submitted.Id]) {
+		t.Errorf("recovered jobs ran out of order: %v", started)
+	}
+
+	// 保存的状态可以再次读取
+	reloaded, err := New(&cfg.JobQueue{Dir: dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		if j, ok := reloaded.Get(test.id); !ok || j.Status != test.status {
+			t.Errorf("reloaded %s: %v", test.id, j)
+		}
+	}
+}
+
+func TestCallback(t *testing.T) {
+	received := make(chan *Job, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign("secret", r.Header.Get(webhook.TimestampHeader), data) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		j := &Job{}
+		_ = json.Unmarshal(data, j)
+		received <- j
+	}))
+	defer hook.Close()
+	clients := []*cfg.ClientPolicy{{Client: "demo", Callbacks: []*cfg.Webhook{{Url: hook.URL, Secret: "secret"}}}}
+	m, err := New(&cfg.JobQueue{Dir: t.TempDir()}, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	submitted, err := m.Submit("demo", task(t, "fast"), nil, hook.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case j := <-received:
+		if j.Id != submitted.Id || j.Status != StatusSucceeded {
+			t.Errorf("callback job = %+v", j)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("callback not received")
+	}
+}
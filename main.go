@@ -6,6 +6,9 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/kaixinhupo/apiagent/config"
 	http2 "github.com/kaixinhupo/apiagent/http"
+	"github.com/kaixinhupo/apiagent/job"
+	"github.com/kaixinhupo/apiagent/schedule"
+	"github.com/kaixinhupo/apiagent/script"
 	"github.com/kaixinhupo/apiagent/server"
 	"io/ioutil"
 	"log"
@@ -15,11 +18,11 @@ import (
 	"time"
 )
 
-var (
-	client = http2.DefaultClient()
-)
-
 func main() {
+	// 作为脚本子进程启动时只执行脚本
+	if script.IsWorker() {
+		os.Exit(script.Serve())
+	}
 	log.Println("代理开始启动")
 	log.Println("读取配置文件")
 	appConfig, err := config.DefaultConfig()
@@ -28,29 +31,67 @@ func main() {
 		waitKey()
 		return
 	}
+	pools, err := http2.DefaultPools()
+	if err != nil {
+		log.Println(err)
+		waitKey()
+		return
+	}
+	jobs, err := schedule.New(appConfig)
+	if err != nil {
+		log.Println(err)
+		waitKey()
+		return
+	}
+	queue, err := job.New(appConfig.Jobs, appConfig.Clients)
+	if err != nil {
+		log.Println(err)
+		waitKey()
+		return
+	}
+	handler, err := server.NewHttpHandler(appConfig, jobs, queue)
+	if err != nil {
+		log.Println("服务设置错误：", err)
+		waitKey()
+		return
+	}
+	// 管理接口在登录前可用,登录时可通过管理接口人工输入验证码
+	serverDone := make(chan struct{})
+	go func() {
+		startHttp(appConfig.Port, handler)
+		close(serverDone)
+	}()
 	count := 0
+	ready := 0
 	for ; count < 5; count++ {
-		client.Login()
-		if client.IsLogin {
+		if ready = pools.LoginAll(); ready == pools.Len() {
 			log.Println("登录成功")
 			break
 		}
-		log.Println("登录失败，5秒后重试")
+		log.Println("部分站点登录失败，5秒后重试")
 		time.Sleep(5 * time.Second)
 	}
-	if client.IsLogin {
-		startHttp(appConfig)
+	if ready > 0 {
+		if ready < pools.Len() {
+			log.Println("部分站点没有可用账号，稍后自动重试登录")
+		}
+		pools.Maintain(nil)
+		go http2.DefaultSessions().Maintain(nil)
+		jobs.Start()
+		queue.Start()
+		<-serverDone
+		jobs.Stop()
 	} else {
 		log.Println("5次重试后依然失败，请确认登录信息")
 		waitKey()
 	}
 }
 
-func startHttp(config *config.Config) {
+func startHttp(port int, handler http.Handler) {
 	mux := http.NewServeMux()
-	mux.Handle("/", &server.HttpHandler{})
-	log.Println("启动服务,端口:", config.Port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", config.Port), mux)
+	mux.Handle("/", handler)
+	log.Println("启动服务,端口:", port)
+	err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
 	if err != nil {
 		log.Println("启动失败：", err)
 		return
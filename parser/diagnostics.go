@@ -0,0 +1,43 @@
+package parser
+
+import "fmt"
+
+const DiagnosticsKey = "_diagnostics"
+
+// 未匹配到内容的规则
+type Diagnostic struct {
+	Step   int    //步骤序号
+	Key    string //键,集合子项为 集合键.子项键
+	Type   string //规则类型
+	Expr   string //表达式
+	Misses int    //未匹配次数
+	Error  string //提取时发生的错误
+}
+
+// 收集一次解析中未匹配的规则,相同键的记录合并计数
+type Diagnostics struct {
+	step    int
+	entries []*Diagnostic
+	index   map[string]*Diagnostic
+}
+
+func newDiagnostics(step int) *Diagnostics {
+	return &Diagnostics{step: step, index: make(map[string]*Diagnostic)}
+}
+
+func (d *Diagnostics) miss(key string, ruleType string, expr string, err error) {
+	entry, ok := d.index[key]
+	if !ok {
+		entry = &Diagnostic{Step: d.step, Key: key, Type: ruleType, Expr: expr}
+		d.index[key] = entry
+		d.entries = append(d.entries, entry)
+	}
+	entry.Misses++
+	if err != nil && entry.Error == "" {
+		entry.Error = fmt.Sprint(err)
+	}
+}
+
+func (d *Diagnostics) Entries() []*Diagnostic {
+	return d.entries
+}
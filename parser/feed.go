@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// 订阅源文档,兼容RSS 2.0、RSS 1.0(RDF)和Atom
+type feedDoc struct {
+	XMLName     xml.Name
+	Channel     *feedChannel `xml:"channel"`
+	Items       []*feedEntry `xml:"item"`
+	Entries     []*feedEntry `xml:"entry"`
+	Title       string       `xml:"title"`
+	Subtitle    string       `xml:"subtitle"`
+	Links       []feedLink   `xml:"link"`
+	Updated     string       `xml:"updated"`
+	Description string       `xml:"description"`
+}
+
+type feedChannel struct {
+	Title         string       `xml:"title"`
+	Links         []feedLink   `xml:"link"`
+	Description   string       `xml:"description"`
+	LastBuildDate string       `xml:"lastBuildDate"`
+	PubDate       string       `xml:"pubDate"`
+	Date          string       `xml:"date"`
+	Items         []*feedEntry `xml:"item"`
+}
+
+// RSS的item和Atom的entry
+type feedEntry struct {
+	Id          string         `xml:"id"`
+	Guid        string         `xml:"guid"`
+	About       string         `xml:"about,attr"`
+	Title       string         `xml:"title"`
+	Links       []feedLink     `xml:"link"`
+	Description string         `xml:"description"`
+	Summary     string         `xml:"summary"`
+	Content     string         `xml:"content"`
+	Encoded     string         `xml:"encoded"`
+	PubDate     string         `xml:"pubDate"`
+	Date        string         `xml:"date"`
+	Published   string         `xml:"published"`
+	Updated     string         `xml:"updated"`
+	Author      feedAuthor     `xml:"author"`
+	Creator     string         `xml:"creator"`
+	Categories  []feedCategory `xml:"category"`
+}
+
+type feedLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+type feedAuthor struct {
+	Name string `xml:"name"`
+	Text string `xml:",chardata"`
+}
+
+type feedCategory struct {
+	Term string `xml:"term,attr"`
+	Text string `xml:",chardata"`
+}
+
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// 将RSS/Atom订阅转换为统一结构,条目放在Items集合中
+func parseFeed(body string) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(strings.NewReader(body))
+	decoder.Strict = false
+	// 响应在解析前已经按Encoding转换为UTF-8
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	doc := &feedDoc{}
+	if err := decoder.Decode(doc); err != nil {
+		return nil, fmt.Errorf("解析订阅源发生错误:%v", err)
+	}
+	result := make(map[string]interface{})
+	var entries []*feedEntry
+	switch strings.ToLower(doc.XMLName.Local) {
+	case "rss", "rdf":
+		if doc.Channel == nil {
+			return nil, fmt.Errorf("订阅源缺少channel节点")
+		}
+		result["Title"] = strings.TrimSpace(doc.Channel.Title)
+		result["Link"] = feedLinkOf(doc.Channel.Links)
+		result["Description"] = strings.TrimSpace(doc.Channel.Description)
+		result["Updated"] = feedTime(doc.Channel.LastBuildDate, doc.Channel.PubDate, doc.Channel.Date)
+		entries = append(doc.Channel.Items, doc.Items...)
+	case "feed":
+		result["Title"] = strings.TrimSpace(doc.Title)
+		result["Link"] = feedLinkOf(doc.Links)
+		result["Description"] = strings.TrimSpace(doc.Subtitle)
+		result["Updated"] = feedTime(doc.Updated)
+		entries = doc.Entries
+	default:
+		return nil, fmt.Errorf("不支持的订阅源格式:%s", doc.XMLName.Local)
+	}
+	items := make([]map[string]interface{}, len(entries))
+	for i, e := range entries {
+		link := feedLinkOf(e.Links)
+		categories := make([]string, 0, len(e.Categories))
+		for _, c := range e.Categories {
+			if c.Term != "" {
+				categories = append(categories, c.Term)
+			} else if text := strings.TrimSpace(c.Text); text != "" {
+				categories = append(categories, text)
+			}
+		}
+		items[i] = map[string]interface{}{
+			"Id":         firstNonEmpty(e.Id, e.Guid, e.About, link),
+			"Title":      strings.TrimSpace(e.Title),
+			"Link":       link,
+			"Summary":    firstNonEmpty(e.Summary, e.Description),
+			"Content":    firstNonEmpty(e.Encoded, e.Content, e.Description, e.Summary),
+			"Author":     firstNonEmpty(e.Author.Name, e.Author.Text, e.Creator),
+			"Published":  feedTime(e.Published, e.PubDate, e.Date, e.Updated),
+			"Updated":    feedTime(e.Updated, e.Published, e.PubDate, e.Date),
+			"Categories": categories,
+		}
+	}
+	result["Items"] = items
+	return result, nil
+}
+
+// RSS的link为文本,Atom的link为href属性,优先取alternate链接
+func feedLinkOf(links []feedLink) string {
+	for _, l := range links {
+		if text := strings.TrimSpace(l.Text); text != "" {
+			return text
+		}
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// 取第一个非空时间,能识别时转换为RFC3339格式
+func feedTime(values ...string) string {
+	val := firstNonEmpty(values...)
+	if val == "" {
+		return ""
+	}
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return val
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
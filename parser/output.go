@@ -1,10 +1,12 @@
 package parser
 
 import (
+	"fmt"
 	config2 "github.com/kaixinhupo/apiagent/config"
 	errors2 "github.com/kaixinhupo/apiagent/errors"
 	"github.com/kaixinhupo/apiagent/util"
 	"log"
+	"strings"
 )
 
 func ParseStepResult(step *config2.Step, body string) (map[string]interface{}, error) {
@@ -14,23 +16,38 @@ func ParseStepResult(step *config2.Step, body string) (map[string]interface{}, e
 	if !step.Output.Extract {
 		return map[string]interface{}{"body": body}, nil
 	}
-	var _body string
-	if step.Output.Scope != "" {
-		parser, err := NewOutputParser(body).ValueByRegex(body)
+	_body := body
+	if len(step.Output.Scope) > 0 {
+		scoped, err := applyScope(step.Output.Scope, body)
 		if err != nil {
 			return nil, err
 		}
-		_body = parser
-	} else {
-		_body = body
+		_body = scoped
+	}
+	if script := step.Output.Script; script != nil {
+		data, err := NewOutputParser(_body).ValueByScript(script.Selector, script.Variable, script.Callback)
+		if err != nil {
+			return nil, err
+		}
+		_body = data
 	}
 	result := make(map[string]interface{})
+	diag := newDiagnostics(step.Sort)
 	context := NewOutputParser(_body)
+	context.Namespaces = step.Output.Namespaces
+	if step.Output.Feed {
+		feed, err := parseFeed(_body)
+		if err != nil {
+			return nil, err
+		}
+		util.CopyMap(feed, result)
+	}
 	log.Println("提取单个元素")
-	rst := extractByRules(step.Output.ItemRules, context)
-	if rst != nil {
-		util.CopyMap(rst, result)
+	rst, err := extractByRules(step.Output.ItemRules, context, "", diag)
+	if err != nil {
+		return nil, err
 	}
+	util.CopyMap(rst, result)
 	collection := step.Output.CollectionRules
 	if collection != nil {
 		log.Println("提取集合元素")
@@ -38,37 +55,66 @@ func ParseStepResult(step *config2.Step, body string) (map[string]interface{}, e
 			if v.Type == config2.TYPE_REGEX {
 				continue
 			}
+			if v.Type == config2.TYPE_TABLE {
+				rows, err := context.TableByCss(v.Expr, v.HeaderRows, v.Renames)
+				if err != nil || len(rows) == 0 {
+					diag.miss(v.Key, v.Type, v.Expr, err)
+				}
+				if rows != nil {
+					result[v.Key] = rows
+				}
+				continue
+			}
 			group, err := collectionByRule(v, context)
-			if err != nil {
-				break
+			if err != nil || len(group) == 0 {
+				diag.miss(v.Key, v.Type, v.Expr, err)
+				if err != nil {
+					continue
+				}
 			}
 			if group != nil {
 				arr := make([]map[string]interface{}, len(group))
 				for i, g := range group {
-					rst = extractByRules(v.ItemRules, NewOutputParser(g))
+					rst, err = extractByRules(v.ItemRules, g, fmt.Sprintf("%s[%d].", v.Key, i), diag)
+					if err != nil {
+						return nil, err
+					}
 					arr[i] = rst
 				}
 				result[v.Key] = arr
 			}
 		}
 	}
+	if step.Output.Diagnostics {
+		result[DiagnosticsKey] = diag.Entries()
+	}
 	log.Println("result len:", len(result))
 	return result, nil
 }
 
-func extractByRules(rules []*config2.ItemRule, context *OutputParser) map[string]interface{} {
+// 按规则提取单项数据,未匹配的规则使用默认值并记录诊断信息,必填项未匹配时返回RuleError
+func extractByRules(rules []*config2.ItemRule, context *OutputParser, prefix string, diag *Diagnostics) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
-	if rules != nil {
-	rulesloop:
-		for _, rule := range rules {
-			val, err := valueByRule(rule, context)
-			if err != nil {
-				break rulesloop
+	for _, rule := range rules {
+		val, err := valueByRule(rule, context)
+		if err != nil || strings.TrimSpace(val) == "" {
+			if rule.Required {
+				return nil, errors2.NewRuleError(diag.step, prefix+rule.Key, rule.Expr)
 			}
-			result[rule.Key] = val
+			diag.miss(diagnosticKey(prefix, rule.Key), rule.Type, rule.Expr, err)
+			val = rule.Default
 		}
+		result[rule.Key] = val
 	}
-	return result
+	return result, nil
+}
+
+// 集合子项的诊断信息按 集合键.子项键 合并,不区分下标
+func diagnosticKey(prefix string, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix[:strings.LastIndex(prefix, "[")] + "." + key
 }
 
 func valueByRule(rule *config2.ItemRule, context *OutputParser) (string, error) {
@@ -79,11 +125,13 @@ func valueByRule(rule *config2.ItemRule, context *OutputParser) (string, error)
 		return extractJsonValue(context, rule)
 	case config2.TYPE_REGEX:
 		return extractRegexValue(context, rule), nil
+	case config2.TYPE_XPATH:
+		return extractXpathValue(context, rule)
 	}
 	return "", nil
 }
 
-func collectionByRule(rule *config2.CollectionRule, context *OutputParser) ([]string, error) {
+func collectionByRule(rule *config2.CollectionRule, context *OutputParser) ([]*OutputParser, error) {
 	var val []string
 	var err error
 	switch rule.Type {
@@ -93,15 +141,25 @@ func collectionByRule(rule *config2.CollectionRule, context *OutputParser) ([]st
 	case config2.TYPE_JSON:
 		val, err = context.SliceByJson(rule.Expr)
 		break
+	case config2.TYPE_XPATH:
+		return context.SliceByXpath(rule.Expr)
 	}
 	if err != nil {
-		if _, ok := err.(errors2.ContextError); ok {
+		if _, ok := err.(*errors2.ContextError); ok {
 			return nil, err
 		} else {
 			return nil, nil
 		}
 	}
-	return val, nil
+	if val == nil {
+		return nil, nil
+	}
+	rst := make([]*OutputParser, len(val))
+	for i, v := range val {
+		rst[i] = NewOutputParser(v)
+		rst[i].Namespaces = context.Namespaces
+	}
+	return rst, nil
 }
 
 func extractJsonValue(parser *OutputParser, rule *config2.ItemRule) (string, error) {
@@ -113,7 +171,7 @@ func extractJsonValue(parser *OutputParser, rule *config2.ItemRule) (string, err
 		val, err = parser.ValueByJson(rule.Expr)
 	}
 	if err != nil {
-		if _, ok := err.(errors2.ContextError); ok {
+		if _, ok := err.(*errors2.ContextError); ok {
 			return "", err
 		} else {
 			return "", nil
@@ -133,13 +191,18 @@ func extractRegexValue(parser *OutputParser, rule *config2.ItemRule) string {
 func extractCssValue(parser *OutputParser, rule *config2.ItemRule) (string, error) {
 	var val string
 	var err error
-	if rule.Regex != "" {
+	if rule.Attr != "" {
+		val, err = parser.ValueByCssAttr(rule.Expr, rule.Attr)
+		if err == nil && rule.Regex != "" {
+			val, err = parser.filterByRegex(val, rule.Regex)
+		}
+	} else if rule.Regex != "" {
 		val, err = parser.ValueByCssWithRegex(rule.Expr, rule.Regex)
 	} else {
 		val, err = parser.ValueByCss(rule.Expr)
 	}
 	if err != nil {
-		if _, ok := err.(errors2.ContextError); ok {
+		if _, ok := err.(*errors2.ContextError); ok {
 			return "", err
 		} else {
 			return "", nil
@@ -147,3 +210,10 @@ func extractCssValue(parser *OutputParser, rule *config2.ItemRule) (string, erro
 	}
 	return val, nil
 }
+
+func extractXpathValue(parser *OutputParser, rule *config2.ItemRule) (string, error) {
+	if rule.Regex != "" {
+		return parser.ValueByXpathWithRegex(rule.Expr, rule.Regex)
+	}
+	return parser.ValueByXpath(rule.Expr)
+}
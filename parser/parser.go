@@ -3,7 +3,9 @@ package parser
 import (
 	"github.com/Jeffail/gabs/v2"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xmlquery"
 	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"github.com/kaixinhupo/apiagent/util"
 	"log"
 	"regexp"
 	"strings"
@@ -11,8 +13,10 @@ import (
 
 type OutputParser struct {
 	Body        string
+	Namespaces  map[string]string //XPath命名空间前缀
 	jsonContext *gabs.Container
 	htmlContext *goquery.Document
+	xmlContext  *xmlquery.Node
 }
 
 func NewOutputParser(body string) *OutputParser {
@@ -24,10 +28,7 @@ func (p *OutputParser) ValueByJson(path string) (string, error) {
 	if ctx == nil {
 		return "", errors2.NewContextError("创建上下文时发生异常")
 	}
-	if val, ok := ctx.Path(path).Data().(string); ok {
-		return val, nil
-	}
-	return "", nil
+	return util.ToString(ctx.Path(path).Data()), nil
 }
 
 func (p *OutputParser) ValueByJsonWithRegex(path string, regex string) (string, error) {
@@ -59,6 +60,16 @@ func (p *OutputParser) ValueByCss(selector string) (string, error) {
 	return ctx.Find(selector).Text(), nil
 }
 
+// 取第一个匹配元素的属性值
+func (p *OutputParser) ValueByCssAttr(selector string, attr string) (string, error) {
+	ctx := p.getHtmlContext()
+	if ctx == nil {
+		return "", errors2.NewContextError("创建上下文时发生异常")
+	}
+	val, _ := ctx.Find(selector).Attr(attr)
+	return val, nil
+}
+
 func (p *OutputParser) ValueByCssWithRegex(selector string, regex string) (string, error) {
 	val, err := p.ValueByCss(selector)
 	if err != nil {
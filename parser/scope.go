@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Jeffail/gabs/v2"
+	"github.com/PuerkitoBio/goquery"
+	config2 "github.com/kaixinhupo/apiagent/config"
+	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"golang.org/x/net/html"
+	"regexp"
+	"strings"
+)
+
+// 依次应用范围处理链,任意一步未匹配时返回ScopeError
+func applyScope(scope config2.Scope, body string) (string, error) {
+	for i, rule := range scope {
+		if rule == nil {
+			continue
+		}
+		val, err := scopeByRule(rule, body)
+		if err != nil {
+			return "", errors2.NewScopeError(fmt.Sprintf("范围处理第%d步(%s:%s)发生错误:%v", i+1, rule.Type, rule.Expr, err))
+		}
+		if strings.TrimSpace(val) == "" {
+			return "", errors2.NewScopeError(fmt.Sprintf("范围处理第%d步(%s:%s)未匹配到内容", i+1, rule.Type, rule.Expr))
+		}
+		body = val
+	}
+	return body, nil
+}
+
+func scopeByRule(rule *config2.ScopeRule, body string) (string, error) {
+	switch rule.Type {
+	case config2.TYPE_REGEX:
+		return scopeByRegex(rule.Expr, body)
+	case config2.TYPE_CSS:
+		return scopeByCss(rule.Expr, body)
+	case config2.TYPE_JSON:
+		return scopeByJson(rule.Expr, body)
+	case config2.SCOPE_STRIP:
+		return stripTags(body)
+	case config2.SCOPE_TIDY:
+		return tidyHtml(body)
+	}
+	return "", fmt.Errorf("不支持的范围处理类型:%s", rule.Type)
+}
+
+// 正则匹配,有分组时取第一个分组,否则取整个匹配
+func scopeByRegex(expr string, body string) (string, error) {
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+	group := reg.FindStringSubmatch(body)
+	if group == nil {
+		return "", nil
+	}
+	if len(group) > 1 {
+		return group[1], nil
+	}
+	return group[0], nil
+}
+
+// CSS子树,多个匹配时依次拼接
+func scopeByCss(selector string, body string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	builder := strings.Builder{}
+	doc.Find(selector).Each(func(i int, sel *goquery.Selection) {
+		if err != nil {
+			return
+		}
+		var str string
+		str, err = goquery.OuterHtml(sel)
+		builder.WriteString(str)
+	})
+	if err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// JSON子树
+func scopeByJson(path string, body string) (string, error) {
+	ctx, err := gabs.ParseJSON([]byte(body))
+	if err != nil {
+		return "", err
+	}
+	node := ctx.Path(path)
+	if node.Data() == nil {
+		return "", nil
+	}
+	return node.String(), nil
+}
+
+// 去除HTML标签,仅保留文本
+func stripTags(body string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	return doc.Text(), nil
+}
+
+// 规范化HTML,补全未闭合的标签
+func tidyHtml(body string) (string, error) {
+	node, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.Buffer{}
+	err = html.Render(&buf, node)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
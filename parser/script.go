@@ -0,0 +1,138 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"regexp"
+	"strings"
+)
+
+var jsonpPattern = regexp.MustCompile(`^[\s;]*(?:/\*\*/)?\s*[\w$.]+\s*\(`)
+
+// 从脚本变量或JSONP回调中提取JSON数据
+// selector为空时在整个响应中查找,variable不为空时按变量赋值查找,否则按JSONP回调查找
+func (p *OutputParser) ValueByScript(selector string, variable string, callback string) (string, error) {
+	var sources []string
+	if selector == "" {
+		sources = []string{p.Body}
+	} else {
+		ctx := p.getHtmlContext()
+		if ctx == nil {
+			return "", errors2.NewContextError("创建上下文时发生异常")
+		}
+		ctx.Find(selector).Each(func(i int, sel *goquery.Selection) {
+			sources = append(sources, sel.Text())
+		})
+	}
+	for _, src := range sources {
+		var payload string
+		var ok bool
+		if variable != "" {
+			payload, ok = findAssignment(src, variable)
+		} else {
+			payload, ok = findCallback(src, callback)
+		}
+		if ok {
+			return payload, nil
+		}
+	}
+	if variable != "" {
+		return "", fmt.Errorf("未找到脚本变量:%s", variable)
+	}
+	return "", fmt.Errorf("未找到JSONP回调:%s", callback)
+}
+
+// 查找形如 name = {...} 或 name = JSON.parse("...") 的赋值
+func findAssignment(src string, name string) (string, bool) {
+	offset := 0
+	for {
+		idx := strings.Index(src[offset:], name)
+		if idx < 0 {
+			return "", false
+		}
+		start := offset + idx
+		end := start + len(name)
+		offset = end
+		if start > 0 && isIdentChar(src[start-1]) {
+			continue
+		}
+		rest := strings.TrimLeft(src[end:], " \t\r\n")
+		if !strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, "==") {
+			continue
+		}
+		rest = strings.TrimLeft(rest[1:], " \t\r\n")
+		if strings.HasPrefix(rest, "JSON.parse(") {
+			rest = strings.TrimLeft(rest[len("JSON.parse("):], " \t\r\n")
+			if payload, ok := decodeJsonString(rest); ok {
+				return payload, true
+			}
+			continue
+		}
+		if payload, ok := decodeJsonValue(rest); ok {
+			return payload, true
+		}
+	}
+}
+
+// 查找形如 callback({...}) 的JSONP调用,callback为空时匹配开头的任意回调
+func findCallback(src string, callback string) (string, bool) {
+	if callback == "" {
+		loc := jsonpPattern.FindStringIndex(src)
+		if loc == nil {
+			return "", false
+		}
+		return decodeJsonValue(src[loc[1]:])
+	}
+	offset := 0
+	for {
+		idx := strings.Index(src[offset:], callback)
+		if idx < 0 {
+			return "", false
+		}
+		start := offset + idx
+		end := start + len(callback)
+		offset = end
+		if start > 0 && isIdentChar(src[start-1]) {
+			continue
+		}
+		rest := strings.TrimLeft(src[end:], " \t\r\n")
+		if !strings.HasPrefix(rest, "(") {
+			continue
+		}
+		if payload, ok := decodeJsonValue(rest[1:]); ok {
+			return payload, true
+		}
+	}
+}
+
+// 解码开头的一个JSON值,忽略其后的内容
+func decodeJsonValue(src string) (string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(src))
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return "", false
+	}
+	return string(raw), true
+}
+
+// 解码开头的JSON字符串,并要求其内容也是JSON
+func decodeJsonString(src string) (string, bool) {
+	if !strings.HasPrefix(src, "\"") {
+		return "", false
+	}
+	decoder := json.NewDecoder(strings.NewReader(src))
+	var str string
+	if err := decoder.Decode(&str); err != nil {
+		return "", false
+	}
+	if !json.Valid([]byte(str)) {
+		return "", false
+	}
+	return str, true
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
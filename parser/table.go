@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"strconv"
+	"strings"
+)
+
+// 被rowspan延续到后续行的单元格
+type cellSpan struct {
+	text string
+	rows int
+}
+
+// 表格中的一行,展开rowspan和colspan后的文本
+type tableRow struct {
+	cells    []string
+	isHeader bool
+	inHead   bool
+}
+
+// 解析HTML表格,以表头为键生成行对象
+// headerRows为0时取thead中的行数,没有thead时取开头全部为th的行数,至少为1
+// renames对生成的列名进行重命名
+func (p *OutputParser) TableByCss(selector string, headerRows int, renames map[string]string) ([]map[string]interface{}, error) {
+	ctx := p.getHtmlContext()
+	if ctx == nil {
+		return nil, errors2.NewContextError("创建上下文时发生异常")
+	}
+	table := ctx.Find(selector).First()
+	if table.Size() == 0 {
+		return nil, nil
+	}
+	rows := expandTable(table)
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if headerRows <= 0 {
+		headerRows = countHeaderRows(rows)
+	}
+	if headerRows > len(rows) {
+		headerRows = len(rows)
+	}
+	keys := tableKeys(rows[:headerRows], renames)
+	result := make([]map[string]interface{}, 0, len(rows)-headerRows)
+	for _, row := range rows[headerRows:] {
+		item := make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			if i < len(row.cells) {
+				item[key] = row.cells[i]
+			} else {
+				item[key] = ""
+			}
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// 展开表格的rowspan和colspan,忽略嵌套表格中的行
+func expandTable(table *goquery.Selection) []*tableRow {
+	var rows []*tableRow
+	carry := make(map[int]*cellSpan)
+	table.Find("tr").Each(func(i int, tr *goquery.Selection) {
+		if !tr.Closest("table").IsSelection(table) {
+			return
+		}
+		cells := tr.ChildrenFiltered("td,th")
+		if cells.Size() == 0 {
+			return
+		}
+		row := &tableRow{isHeader: true, inHead: tr.Parent().Is("thead")}
+		col := 0
+		fill := func() {
+			for {
+				text, ok := takeSpan(carry, col)
+				if !ok {
+					return
+				}
+				row.cells = append(row.cells, text)
+				col++
+			}
+		}
+		cells.Each(func(j int, cell *goquery.Selection) {
+			fill()
+			if !cell.Is("th") {
+				row.isHeader = false
+			}
+			text := strings.TrimSpace(cell.Text())
+			colspan := spanAttr(cell, "colspan")
+			rowspan := spanAttr(cell, "rowspan")
+			for k := 0; k < colspan; k++ {
+				row.cells = append(row.cells, text)
+				if rowspan > 1 {
+					carry[col] = &cellSpan{text: text, rows: rowspan - 1}
+				}
+				col++
+			}
+		})
+		// 行尾之后仍有上方延续下来的单元格,中间的空位补空
+		width := col
+		for c := range carry {
+			if c >= width {
+				width = c + 1
+			}
+		}
+		for ; col < width; col++ {
+			text, _ := takeSpan(carry, col)
+			row.cells = append(row.cells, text)
+		}
+		rows = append(rows, row)
+	})
+	return rows
+}
+
+// 取出指定列上延续的单元格文本
+func takeSpan(carry map[int]*cellSpan, col int) (string, bool) {
+	span, ok := carry[col]
+	if !ok {
+		return "", false
+	}
+	span.rows--
+	if span.rows == 0 {
+		delete(carry, col)
+	}
+	return span.text, true
+}
+
+func spanAttr(cell *goquery.Selection, name string) int {
+	val, ok := cell.Attr(name)
+	if !ok {
+		return 1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+func countHeaderRows(rows []*tableRow) int {
+	count := 0
+	for _, row := range rows {
+		if !row.inHead {
+			break
+		}
+		count++
+	}
+	if count > 0 {
+		return count
+	}
+	for _, row := range rows {
+		if !row.isHeader {
+			break
+		}
+		count++
+	}
+	if count == 0 {
+		return 1
+	}
+	return count
+}
+
+// 多行表头按列以下划线连接,空列名使用colN,重复列名追加序号
+func tableKeys(headers []*tableRow, renames map[string]string) []string {
+	width := 0
+	for _, row := range headers {
+		if len(row.cells) > width {
+			width = len(row.cells)
+		}
+	}
+	keys := make([]string, width)
+	used := make(map[string]int, width)
+	for c := 0; c < width; c++ {
+		var parts []string
+		for _, row := range headers {
+			if c >= len(row.cells) || row.cells[c] == "" {
+				continue
+			}
+			text := row.cells[c]
+			if len(parts) > 0 && parts[len(parts)-1] == text {
+				continue
+			}
+			parts = append(parts, text)
+		}
+		key := strings.Join(parts, "_")
+		if key == "" {
+			key = fmt.Sprintf("col%d", c+1)
+		}
+		if rename, ok := renames[key]; ok {
+			key = rename
+		}
+		used[key]++
+		if n := used[key]; n > 1 {
+			key = fmt.Sprintf("%s_%d", key, n)
+		}
+		keys[c] = key
+	}
+	return keys
+}
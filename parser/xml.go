@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	errors2 "github.com/kaixinhupo/apiagent/errors"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var xmlEncodingPattern = regexp.MustCompile(`^(\s*<\?xml[^>]*?)\s+encoding=["'][^"']*["']`)
+
+// 为XML节点创建解析器,子规则以该节点为根进行查询
+func newXmlNodeParser(node *xmlquery.Node, namespaces map[string]string) *OutputParser {
+	return &OutputParser{Body: node.OutputXML(true), Namespaces: namespaces, xmlContext: node}
+}
+
+func (p *OutputParser) ValueByXpath(expr string) (string, error) {
+	ctx := p.getXmlContext()
+	if ctx == nil {
+		return "", errors2.NewContextError("创建上下文时发生异常")
+	}
+	compiled, err := p.compileXpath(expr)
+	if err != nil {
+		return "", err
+	}
+	switch val := compiled.Evaluate(xmlquery.CreateXPathNavigator(ctx)).(type) {
+	case *xpath.NodeIterator:
+		if val.MoveNext() {
+			return val.Current().Value(), nil
+		}
+		return "", nil
+	case string:
+		return val, nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case bool:
+		if val {
+			return "true", nil
+		}
+		return "false", nil
+	}
+	return "", nil
+}
+
+func (p *OutputParser) ValueByXpathWithRegex(expr string, regex string) (string, error) {
+	val, err := p.ValueByXpath(expr)
+	if err != nil {
+		return "", err
+	}
+	return p.filterByRegex(val, regex)
+}
+
+func (p *OutputParser) SliceByXpath(expr string) ([]*OutputParser, error) {
+	ctx := p.getXmlContext()
+	if ctx == nil {
+		return nil, errors2.NewContextError("创建上下文时发生异常")
+	}
+	compiled, err := p.compileXpath(expr)
+	if err != nil {
+		return nil, err
+	}
+	nodes := xmlquery.QuerySelectorAll(ctx, compiled)
+	log.Println("xpath:", expr, " length:", len(nodes))
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	rst := make([]*OutputParser, len(nodes))
+	for i, node := range nodes {
+		rst[i] = newXmlNodeParser(node, p.Namespaces)
+	}
+	return rst, nil
+}
+
+// 编译XPath,表达式中的前缀按Namespaces映射到命名空间
+func (p *OutputParser) compileXpath(expr string) (*xpath.Expr, error) {
+	if len(p.Namespaces) == 0 {
+		return xpath.Compile(expr)
+	}
+	return xpath.CompileWithNS(expr, p.Namespaces)
+}
+
+func (p *OutputParser) getXmlContext() *xmlquery.Node {
+	if p.xmlContext != nil {
+		return p.xmlContext
+	}
+	if p.Body == "" {
+		return nil
+	}
+	// 响应在解析前已经按Encoding转换为UTF-8,忽略声明中的编码避免重复转换
+	body := xmlEncodingPattern.ReplaceAllString(p.Body, "${1}")
+	ctx, err := xmlquery.Parse(strings.NewReader(body))
+	if err != nil {
+		log.Println("创建XmlContext时发生错误:", err)
+		return nil
+	}
+	p.xmlContext = ctx
+	return p.xmlContext
+}
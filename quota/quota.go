@@ -0,0 +1,119 @@
+package quota
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+// 调用方的使用情况
+type Usage struct {
+	Client      string    //调用方名称
+	Minute      int       //当前分钟的请求数
+	Day         int       //当天的请求数
+	PerMinute   int       //每分钟请求数上限,0为不限制
+	PerDay      int       //每天请求数上限,0为不限制
+	Requests    int64     //通过的请求总数
+	Throttled   int64     //超过配额被拒绝的请求数
+	Denied      int64     //无权调用被拒绝的请求数
+	Errors      int64     //执行失败的请求数
+	LastRequest time.Time //最近一次请求的时间
+}
+
+type counter struct {
+	usage       Usage
+	minuteStart time.Time
+	dayStart    time.Time
+}
+
+// 按调用方统计请求数并限制配额,分钟和天使用固定窗口
+type Limiter struct {
+	lock     sync.Mutex
+	policies map[string]*cfg.ClientPolicy
+	counters map[string]*counter
+	now      func() time.Time
+}
+
+func NewLimiter(policies []*cfg.ClientPolicy) *Limiter {
+	l := &Limiter{policies: make(map[string]*cfg.ClientPolicy, len(policies)), counters: make(map[string]*counter), now: time.Now}
+	for _, p := range policies {
+		l.policies[p.Client] = p
+	}
+	return l
+}
+
+// 调用方的权限和配额,未配置时返回nil
+func (l *Limiter) Policy(client string) *cfg.ClientPolicy {
+	return l.policies[client]
+}
+
+// 占用一次配额,超过每分钟或每天的上限时返回false
+func (l *Limiter) Allow(client string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	c := l.counter(client)
+	if (c.usage.PerMinute > 0 && c.usage.Minute >= c.usage.PerMinute) || (c.usage.PerDay > 0 && c.usage.Day >= c.usage.PerDay) {
+		c.usage.Throttled++
+		return false
+	}
+	c.usage.Minute++
+	c.usage.Day++
+	c.usage.Requests++
+	c.usage.LastRequest = l.now()
+	return true
+}
+
+// 记录无权调用
+func (l *Limiter) Deny(client string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.counter(client).usage.Denied++
+}
+
+// 记录执行失败
+func (l *Limiter) Fail(client string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.counter(client).usage.Errors++
+}
+
+// 所有调用方的使用情况,按名称排序
+func (l *Limiter) Usages() []*Usage {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	list := make([]*Usage, 0, len(l.counters))
+	for client := range l.counters {
+		usage := l.counter(client).usage
+		list = append(list, &usage)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Client < list[j].Client
+	})
+	return list
+}
+
+// 取得调用方的计数器,并在进入新的分钟或新的一天时重置
+func (l *Limiter) counter(client string) *counter {
+	now := l.now()
+	c, ok := l.counters[client]
+	if !ok {
+		c = &counter{usage: Usage{Client: client}}
+		if p := l.policies[client]; p != nil {
+			c.usage.PerMinute = p.PerMinute
+			c.usage.PerDay = p.PerDay
+		}
+		l.counters[client] = c
+	}
+	if minute := now.Truncate(time.Minute); !minute.Equal(c.minuteStart) {
+		c.minuteStart = minute
+		c.usage.Minute = 0
+	}
+	year, month, day := now.Date()
+	if today := time.Date(year, month, day, 0, 0, 0, 0, now.Location()); !today.Equal(c.dayStart) {
+		c.dayStart = today
+		c.usage.Day = 0
+	}
+	return c
+}
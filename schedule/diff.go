@@ -0,0 +1,126 @@
+package schedule
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	"github.com/kaixinhupo/apiagent/util"
+)
+
+// 两次执行结果的差异
+type Diff struct {
+	Schedule string                   //定时任务名称
+	Task     string                   //任务的完整名称
+	Time     time.Time                //本次执行时间
+	Added    []map[string]interface{} //新增的元素
+	Removed  []map[string]interface{} //删除的元素
+	Changed  []*Change                //变化的元素
+}
+
+// 变化的元素
+type Change struct {
+	Key    string                 //元素的标识
+	Fields []string               //变化的字段
+	Before map[string]interface{} //上次的值
+	After  map[string]interface{} //本次的值
+}
+
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// 按规则比较两次结果,集合元素按Key对应,未设置集合时整个结果作为一个元素
+func compare(rule *cfg.DiffRule, before map[string]interface{}, after map[string]interface{}) *Diff {
+	diff := &Diff{}
+	if rule.Collection == "" {
+		if fields := changedFields(rule.Fields, before, after); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, &Change{Fields: fields, Before: before, After: after})
+		}
+		return diff
+	}
+	beforeItems, beforeKeys := indexItems(rule, before)
+	afterItems, afterKeys := indexItems(rule, after)
+	for _, key := range afterKeys {
+		item := afterItems[key]
+		prev, ok := beforeItems[key]
+		if !ok {
+			diff.Added = append(diff.Added, item)
+			continue
+		}
+		if fields := changedFields(rule.Fields, prev, item); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, &Change{Key: key, Fields: fields, Before: prev, After: item})
+		}
+	}
+	for _, key := range beforeKeys {
+		if _, ok := afterItems[key]; !ok {
+			diff.Removed = append(diff.Removed, beforeItems[key])
+		}
+	}
+	return diff
+}
+
+// 按标识索引集合元素,返回的键保持集合中的顺序,没有标识的元素使用其JSON作为标识
+func indexItems(rule *cfg.DiffRule, result map[string]interface{}) (map[string]map[string]interface{}, []string) {
+	items := make(map[string]map[string]interface{})
+	var keys []string
+	value, _ := util.Lookup(result, strings.Split(rule.Collection, "."))
+	var list []map[string]interface{}
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		list = v
+	case []interface{}:
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				list = append(list, m)
+			}
+		}
+	}
+	for _, item := range list {
+		var key string
+		if rule.Key != "" {
+			if v, ok := util.Lookup(item, strings.Split(rule.Key, ".")); ok {
+				key = util.ToString(v)
+			}
+		}
+		if key == "" {
+			data, _ := json.Marshal(item)
+			key = string(data)
+		}
+		if _, ok := items[key]; ok {
+			continue
+		}
+		items[key] = item
+		keys = append(keys, key)
+	}
+	return items, keys
+}
+
+// 变化的字段,fields为空时比较除诊断、错误等以_开头的字段外的全部字段
+func changedFields(fields []string, before map[string]interface{}, after map[string]interface{}) []string {
+	if len(fields) == 0 {
+		seen := make(map[string]bool)
+		for _, m := range []map[string]interface{}{before, after} {
+			for k := range m {
+				if !seen[k] && !strings.HasPrefix(k, "_") {
+					seen[k] = true
+					fields = append(fields, k)
+				}
+			}
+		}
+		sort.Strings(fields)
+	}
+	var changed []string
+	for _, f := range fields {
+		parts := strings.Split(f, ".")
+		prev, _ := util.Lookup(before, parts)
+		current, _ := util.Lookup(after, parts)
+		if !reflect.DeepEqual(prev, current) {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,145 @@
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+func items(list ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"data": map[string]interface{}{"items": list}}
+}
+
+func item(id string, title string, price float64) map[string]interface{} {
+	return map[string]interface{}{"Id": id, "Title": title, "Price": price}
+}
+
+func TestCompare(t *testing.T) {
+	keyed := &cfg.DiffRule{Collection: "data.items", Key: "Id"}
+	tests := []struct {
+		name    string
+		rule    *cfg.DiffRule
+		before  map[string]interface{}
+		after   map[string]interface{}
+		added   []string
+		removed []string
+		changed map[string][]string
+	}{
+		{
+			name:   "unchanged",
+			rule:   keyed,
+			before: items(item("1", "a", 1), item("2", "b", 2)),
+			after:  items(item("2", "b", 2), item("1", "a", 1)),
+		},
+		{
+			name:    "added and removed",
+			rule:    keyed,
+			before:  items(item("1", "a", 1), item("2", "b", 2)),
+			after:   items(item("2", "b", 2), item("3", "c", 3)),
+			added:   []string{"3"},
+			removed: []string{"1"},
+		},
+		{
+			name:    "changed fields are sorted",
+			rule:    keyed,
+			before:  items(item("1", "a", 1)),
+			after:   items(item("1", "b", 2)),
+			changed: map[string][]string{"1": {"Price", "Title"}},
+		},
+		{
+			name:   "only listed fields",
+			rule:   &cfg.DiffRule{Collection: "data.items", Key: "Id", Fields: []string{"Price"}},
+			before: items(item("1", "a", 1)),
+			after:  items(item("1", "b", 1)),
+		},
+		{
+			name:    "items without key use their JSON",
+			rule:    &cfg.DiffRule{Collection: "data.items"},
+			before:  items(item("1", "a", 1)),
+			after:   items(item("1", "a", 2)),
+			added:   []string{"1"},
+			removed: []string{"1"},
+		},
+		{
+			name:    "missing collection",
+			rule:    keyed,
+			before:  map[string]interface{}{},
+			after:   items(item("1", "a", 1)),
+			added:   []string{"1"},
+			removed: nil,
+		},
+		{
+			name:    "whole result ignores underscore keys",
+			rule:    &cfg.DiffRule{},
+			before:  map[string]interface{}{"Count": 1.0, "_warnings": []string{"x"}},
+			after:   map[string]interface{}{"Count": 2.0},
+			changed: map[string][]string{"": {"Count"}},
+		},
+		{
+			name:   "whole result unchanged",
+			rule:   &cfg.DiffRule{},
+			before: map[string]interface{}{"Count": 1.0, "_errors": []string{"x"}},
+			after:  map[string]interface{}{"Count": 1.0},
+		},
+	}
+	ids := func(list []map[string]interface{}) []string {
+		var rst []string
+		for _, m := range list {
+			rst = append(rst, m["Id"].(string))
+		}
+		return rst
+	}
+	for _, test := range tests {
+		diff := compare(test.rule, test.before, test.after)
+		if got := ids(diff.Added); !reflect.DeepEqual(got, test.added) {
+			t.Errorf("%s: added = %v, want %v", test.name, got, test.added)
+		}
+		if got := ids(diff.Removed); !reflect.DeepEqual(got, test.removed) {
+			t.Errorf("%s: removed = %v, want %v", test.name, got, test.removed)
+		}
+		changed := make(map[string][]string)
+		for _, c := range diff.Changed {
+			changed[c.Key] = c.Fields
+		}
+		if len(changed) != len(test.changed) || (len(changed) > 0 && !reflect.DeepEqual(changed, test.changed)) {
+			t.Errorf("%s: changed = %v, want %v", test.name, changed, test.changed)
+		}
+		if diff.Empty() != (len(test.added)+len(test.removed)+len(test.changed) == 0) {
+			t.Errorf("%s: Empty() = %v", test.name, diff.Empty())
+		}
+	}
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	rule := &cfg.DiffRule{Collection: "data.items", Key: "Id"}
+	s := &Scheduler{dir: t.TempDir()}
+	rst := map[string]interface{}{"data": map[string]interface{}{"items": []map[string]interface{}{
+		{"Id": "1", "Title": "a", "Count": 3},
+	}}}
+	now := time.Now()
+	runs := []*Run{{Started: now, Finished: now, Error: "timeout"}, {Started: now, Finished: now, Result: rst}}
+	s.save(&state{Schedule: "shop/list", Time: now, Result: normalize(rst), Runs: runs})
+
+	restarted := &Scheduler{dir: s.dir}
+	saved := restarted.load("shop/list")
+	if saved == nil || saved.Result == nil {
+		t.Fatal("last result not loaded")
+	}
+	if len(saved.Runs) != 2 || saved.Runs[0].Error != "timeout" || saved.Runs[1].Result == nil {
+		t.Errorf("runs not restored: %+v", saved.Runs)
+	}
+	last := saved.Result
+	if diff := compare(rule, last, normalize(rst)); !diff.Empty() {
+		t.Errorf("same result after restart reported as changed: %+v", diff)
+	}
+	rst["data"].(map[string]interface{})["items"] = []map[string]interface{}{{"Id": "1", "Title": "b", "Count": 3}}
+	diff := compare(rule, last, normalize(rst))
+	if len(diff.Changed) != 1 || !reflect.DeepEqual(diff.Changed[0].Fields, []string{"Title"}) {
+		t.Errorf("change across restart not detected: %+v", diff)
+	}
+	if restarted.load("other") != nil {
+		t.Error("unexpected result for unknown schedule")
+	}
+}
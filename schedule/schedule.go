@@ -0,0 +1,278 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+	http2 "github.com/kaixinhupo/apiagent/http"
+	"github.com/kaixinhupo/apiagent/webhook"
+	"github.com/robfig/cron/v3"
+)
+
+const defaultKeep = 10
+
+// 一次定时执行的结果
+type Run struct {
+	Started  time.Time
+	Finished time.Time
+	Result   map[string]interface{}
+	Error    string
+	Diff     *Diff //与上次成功结果的差异,配置了Diff且不是第一次成功执行时才有
+}
+
+// 定时任务的状态
+type Status struct {
+	Name    string    //名称
+	Cron    string    //cron表达式
+	Task    string    //任务的完整名称
+	Running int       //执行中的次数
+	Prev    time.Time //上次执行时间
+	Next    time.Time //下次执行时间
+}
+
+type entry struct {
+	id       cron.EntryID
+	schedule *cfg.Schedule
+	task     *cfg.Task
+	lock     sync.Mutex
+	running  int
+	runs     []*Run
+	last     map[string]interface{} //上次成功的结果
+	lastTime time.Time              //上次成功的执行时间
+}
+
+// 保存在磁盘上的定时任务状态,重启后恢复最近的执行结果,并继续用上次成功的结果比较
+type state struct {
+	Schedule string
+	Time     time.Time              //上次成功的执行时间,配置了Diff时才有
+	Result   map[string]interface{} //上次成功的结果,配置了Diff时才有
+	Runs     []*Run                 //最近的执行结果
+}
+
+// 定时执行任务,最近的执行结果保存在磁盘上
+type Scheduler struct {
+	cron    *cron.Cron
+	entries []*entry
+	dir     string
+}
+
+var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// 按配置创建调度器,校验cron表达式和任务
+func New(config *cfg.Config) (*Scheduler, error) {
+	s := &Scheduler{cron: cron.New(cron.WithParser(parser)), dir: filepath.Join(config.Jobs.Path(), "schedules")}
+	names := make(map[string]bool, len(config.Schedules))
+	for _, sc := range config.Schedules {
+		if sc.Name == "" || names[sc.Name] {
+			return nil, fmt.Errorf("定时任务名称为空或重复:%s", sc.Name)
+		}
+		names[sc.Name] = true
+		task := config.GetTaskByName(sc.Task)
+		if task == nil {
+			return nil, fmt.Errorf("定时任务[%s]的任务[%s]不存在", sc.Name, sc.Task)
+		}
+		var wrapper cron.JobWrapper
+		switch sc.Overlap {
+		case cfg.OVERLAP_SKIP, "":
+			wrapper = cron.SkipIfStillRunning(cron.DefaultLogger)
+		case cfg.OVERLAP_QUEUE:
+			wrapper = cron.DelayIfStillRunning(cron.DefaultLogger)
+		case cfg.OVERLAP_ALLOW:
+			wrapper = func(j cron.Job) cron.Job { return j }
+		default:
+			return nil, fmt.Errorf("定时任务[%s]不支持的重叠策略:%s", sc.Name, sc.Overlap)
+		}
+		e := &entry{schedule: sc, task: task}
+		id, err := s.cron.AddJob(sc.Cron, cron.NewChain(cron.Recover(cron.DefaultLogger), wrapper).Then(cron.FuncJob(func() {
+			s.run(e)
+		})))
+		if err != nil {
+			return nil, fmt.Errorf("定时任务[%s]的cron表达式错误:%v", sc.Name, err)
+		}
+		e.id = id
+		if saved := s.load(sc.Name); saved != nil {
+			e.runs = saved.Runs
+			if len(e.runs) > e.keep() {
+				e.runs = e.runs[len(e.runs)-e.keep():]
+			}
+			if sc.Diff != nil {
+				e.last, e.lastTime = saved.Result, saved.Time
+			}
+		}
+		s.entries = append(s.entries, e)
+	}
+	return s, nil
+}
+
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// 停止调度,等待执行中的任务结束
+func (s *Scheduler) Stop() {
+	<-s.cron.Stop().Done()
+}
+
+func (s *Scheduler) run(e *entry) {
+	e.lock.Lock()
+	e.running++
+	e.lock.Unlock()
+
+	log.Println("执行定时任务:", e.schedule.Name)
+	run := &Run{Started: time.Now()}
+	rst, err := http2.RunTask(e.task, e.schedule.Inputs)
+	run.Finished = time.Now()
+	if err != nil {
+		log.Println("定时任务执行失败:", e.schedule.Name, err)
+		run.Error = err.Error()
+	} else {
+		run.Result = rst
+	}
+
+	e.lock.Lock()
+	e.running--
+	rule := e.schedule.Diff
+	if rule != nil && err == nil {
+		// 按JSON统一数值和集合的类型,与从磁盘读取的结果可以直接比较
+		current := normalize(rst)
+		if e.last != nil {
+			run.Diff = compare(rule, e.last, current)
+			run.Diff.Schedule = e.schedule.Name
+			run.Diff.Task = e.task.FullName()
+			run.Diff.Time = run.Started
+		}
+		e.last, e.lastTime = current, run.Started
+	}
+	e.runs = append(e.runs, run)
+	if len(e.runs) > e.keep() {
+		e.runs = e.runs[len(e.runs)-e.keep():]
+	}
+	s.save(&state{Schedule: e.schedule.Name, Time: e.lastTime, Result: e.last, Runs: e.runs})
+	e.lock.Unlock()
+
+	if run.Diff != nil && !run.Diff.Empty() && len(rule.Webhooks) > 0 {
+		log.Println("定时任务结果发生变化,发送通知:", e.schedule.Name)
+		webhook.Notify(rule.Webhooks, run.Diff)
+	}
+}
+
+// 保留的执行结果数量
+func (e *entry) keep() int {
+	if e.schedule.Keep <= 0 {
+		return defaultKeep
+	}
+	return e.schedule.Keep
+}
+
+func (s *Scheduler) statePath(name string) string {
+	return filepath.Join(s.dir, url.PathEscape(name)+".json")
+}
+
+// 读取保存的状态,不存在或无法读取时返回nil
+func (s *Scheduler) load(name string) *state {
+	data, err := ioutil.ReadFile(s.statePath(name))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("读取定时任务状态发生错误:", name, err)
+		}
+		return nil
+	}
+	saved := &state{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		log.Println("定时任务状态格式错误,忽略:", name, err)
+		return nil
+	}
+	return saved
+}
+
+// 保存状态,先写入临时文件再重命名
+func (s *Scheduler) save(saved *state) {
+	data, err := json.Marshal(saved)
+	if err == nil {
+		err = os.MkdirAll(s.dir, 0755)
+	}
+	if err != nil {
+		log.Println("保存定时任务状态发生错误:", saved.Schedule, err)
+		return
+	}
+	tmp, err := ioutil.TempFile(s.dir, "tmp-*")
+	if err != nil {
+		log.Println("保存定时任务状态发生错误:", saved.Schedule, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	_ = tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.statePath(saved.Schedule))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		log.Println("保存定时任务状态发生错误:", saved.Schedule, err)
+	}
+}
+
+// JSON序列化后再解析,数值统一为float64,集合统一为[]interface{}
+func normalize(rst map[string]interface{}) map[string]interface{} {
+	data, err := json.Marshal(rst)
+	if err != nil {
+		return rst
+	}
+	normalized := make(map[string]interface{})
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return rst
+	}
+	return normalized
+}
+
+func (s *Scheduler) find(name string) *entry {
+	for _, e := range s.entries {
+		if e.schedule.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// 定时任务对应的任务
+func (s *Scheduler) Task(name string) (*cfg.Task, bool) {
+	e := s.find(name)
+	if e == nil {
+		return nil, false
+	}
+	return e.task, true
+}
+
+// 最近的执行结果,最新的在前
+func (s *Scheduler) Runs(name string) ([]*Run, bool) {
+	e := s.find(name)
+	if e == nil {
+		return nil, false
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	runs := make([]*Run, len(e.runs))
+	for i, r := range e.runs {
+		runs[len(e.runs)-1-i] = r
+	}
+	return runs, true
+}
+
+// 所有定时任务的状态
+func (s *Scheduler) List() []*Status {
+	list := make([]*Status, len(s.entries))
+	for i, e := range s.entries {
+		c := s.cron.Entry(e.id)
+		e.lock.Lock()
+		list[i] = &Status{Name: e.schedule.Name, Cron: e.schedule.Cron, Task: e.task.FullName(), Running: e.running, Prev: c.Prev, Next: c.Next}
+		e.lock.Unlock()
+	}
+	return list
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package script
+
+import "syscall"
+
+// 子进程运行时本身需要的内存,加在脚本内存上限之上
+const runtimeMemory = 64 << 20
+
+// 限制进程的数据段大小,超过时内存分配失败,进程退出
+func limitMemory(maxMemory uint64) error {
+	if raceEnabled {
+		return nil
+	}
+	limit := maxMemory + runtimeMemory
+	return syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: limit, Max: limit})
+}
@@ -0,0 +1,6 @@
+package script
+
+// Windows没有进程资源限制,只通过检查堆内存限制脚本
+func limitMemory(maxMemory uint64) error {
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package script
+
+// 竞态检测需要大量额外内存,不设置进程内存上限
+const raceEnabled = true
@@ -0,0 +1,140 @@
+package script
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout   = time.Second
+	defaultMaxMemory = 64 << 20
+	// 子进程启动和传递数据的额外时间
+	startTimeout = 5 * time.Second
+)
+
+// 子进程的退出码
+const (
+	exitTimeout = 3
+	exitMemory  = 4
+)
+
+var errTimeout = errors.New("脚本执行超时")
+
+// 脚本运行限制
+type Limits struct {
+	Timeout   time.Duration //超时时间,默认1秒
+	MaxMemory uint64        //内存上限,默认64MB
+}
+
+// 在独立的子进程中执行脚本,globals以JSON形式传入,执行后按names读取全局变量
+// 子进程的内存有上限,脚本耗尽内存时只有子进程退出
+func Run(source string, globals map[string]interface{}, names []string, limits Limits) (map[string]interface{}, error) {
+	timeout := limits.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	maxMemory := limits.MaxMemory
+	if maxMemory == 0 {
+		maxMemory = defaultMaxMemory
+	}
+	input, err := json.Marshal(&request{Source: source, Globals: globals, Names: names, Timeout: timeout, MaxMemory: maxMemory})
+	if err != nil {
+		return nil, err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+startTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, executable)
+	cmd.Env = append(os.Environ(), workerEnv+"=1")
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return nil, errTimeout
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			switch exitErr.ExitCode() {
+			case exitTimeout:
+				return nil, errTimeout
+			case exitMemory:
+				return nil, errMemory
+			}
+		}
+		// 超过进程内存上限时运行时无法分配内存,进程直接退出
+		if line := firstLine(stderr.String()); strings.Contains(line, "out of memory") || strings.Contains(line, "cannot allocate memory") {
+			return nil, errMemory
+		}
+		return nil, fmt.Errorf("脚本进程异常退出:%v %s", err, firstLine(stderr.String()))
+	}
+	scanner := bufio.NewScanner(&stderr)
+	for scanner.Scan() {
+		log.Println("[script]", scanner.Text())
+	}
+
+	var resp response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	for name, val := range resp.Result {
+		resp.Result[name] = normalize(val)
+	}
+	return resp.Result, nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// 元素都是对象的数组转换为[]map[string]interface{},与解析规则生成的集合类型一致
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalize(item)
+		}
+		return v
+	case []map[string]interface{}:
+		for i, item := range v {
+			v[i] = normalize(item).(map[string]interface{})
+		}
+		return v
+	case []interface{}:
+		objects := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			v[i] = normalize(item)
+			obj, ok := v[i].(map[string]interface{})
+			if !ok {
+				return v
+			}
+			objects[i] = obj
+		}
+		if len(v) == 0 {
+			return v
+		}
+		return objects
+	}
+	return val
+}
@@ -0,0 +1,46 @@
+package script
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if IsWorker() {
+		os.Exit(Serve())
+	}
+	os.Exit(m.Run())
+}
+
+func TestRun(t *testing.T) {
+	globals := map[string]interface{}{"ctx": map[string]interface{}{"page": 1}}
+	rst, err := Run("ctx.page += 1; log('page', ctx.page)", globals, []string{"ctx"}, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := rst["ctx"].(map[string]interface{})
+	if ctx["page"] != float64(2) {
+		t.Fatalf("page = %v", ctx["page"])
+	}
+}
+
+func TestRunError(t *testing.T) {
+	if _, err := Run("undefinedFunction()", nil, nil, Limits{}); err == nil {
+		t.Fatal("expected script error")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	_, err := Run("while(true){}", nil, nil, Limits{Timeout: 100 * time.Millisecond})
+	if err != errTimeout {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestRunMemory(t *testing.T) {
+	_, err := Run("var a=[];while(1)a.push(new Array(1e6))", nil, nil, Limits{Timeout: 10 * time.Second, MaxMemory: 32 << 20})
+	if err != errMemory {
+		t.Fatalf("err = %v", err)
+	}
+}
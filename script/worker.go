@@ -0,0 +1,158 @@
+package script
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/robertkrimen/otto"
+	"log"
+	"os"
+	"runtime"
+	"time"
+)
+
+// 设置该环境变量的进程作为脚本子进程运行
+const workerEnv = "APIAGENT_SCRIPT_WORKER"
+
+// 子进程检查堆内存的间隔
+const memoryInterval = 10 * time.Millisecond
+
+var errMemory = errors.New("脚本使用内存超过上限")
+
+// 传给子进程的脚本和全局变量
+type request struct {
+	Source    string
+	Globals   map[string]interface{}
+	Names     []string
+	Timeout   time.Duration
+	MaxMemory uint64
+}
+
+// 子进程返回的执行结果,Error为脚本错误
+type response struct {
+	Result map[string]interface{}
+	Error  string
+}
+
+// 当前进程是否为脚本子进程,main函数开始时检查,是则调用Serve后退出
+func IsWorker() bool {
+	return os.Getenv(workerEnv) == "1"
+}
+
+// 从标准输入读取脚本,执行后将结果写入标准输出,返回进程退出码
+// 子进程设置内存上限,超过上限时进程以exitMemory退出
+func Serve() int {
+	log.SetFlags(0)
+	var req request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		log.Println("读取脚本失败:", err)
+		return 1
+	}
+	if err := limitMemory(req.MaxMemory); err != nil {
+		log.Println("设置内存上限失败:", err)
+		return 1
+	}
+	go watchMemory(req.MaxMemory)
+
+	var resp response
+	rst, err := execute(req.Source, req.Globals, req.Names, req.Timeout)
+	if err == errTimeout {
+		return exitTimeout
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	resp.Result = rst
+	if err := json.NewEncoder(os.Stdout).Encode(&resp); err != nil {
+		log.Println("写入脚本结果失败:", err)
+		return 1
+	}
+	return 0
+}
+
+// 堆内存超过上限时退出,进程的内存上限是最终的保证,这里用于及时给出明确的错误
+func watchMemory(maxMemory uint64) {
+	var stats runtime.MemStats
+	for range time.Tick(memoryInterval) {
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc > maxMemory {
+			os.Exit(exitMemory)
+		}
+	}
+}
+
+// 在解释器中执行脚本,超时后中断
+func execute(source string, globals map[string]interface{}, names []string, timeout time.Duration) (rst map[string]interface{}, err error) {
+	vm := otto.New()
+	vm.Interrupt = make(chan func(), 1)
+	if err := setGlobals(vm, globals); err != nil {
+		return nil, err
+	}
+	_ = vm.Set("log", func(call otto.FunctionCall) otto.Value {
+		args := make([]interface{}, len(call.ArgumentList))
+		for i, arg := range call.ArgumentList {
+			args[i] = arg.String()
+		}
+		log.Println(args...)
+		return otto.UndefinedValue()
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go watch(vm, done, timeout)
+
+	defer func() {
+		if caught := recover(); caught != nil {
+			if e, ok := caught.(error); ok && e == errTimeout {
+				rst, err = nil, e
+				return
+			}
+			panic(caught)
+		}
+	}()
+	if _, err := vm.Run(source); err != nil {
+		return nil, fmt.Errorf("脚本执行错误:%v", err)
+	}
+	rst = make(map[string]interface{}, len(names))
+	for _, name := range names {
+		val, err := vm.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		exported, err := val.Export()
+		if err != nil {
+			return nil, err
+		}
+		rst[name] = exported
+	}
+	return rst, nil
+}
+
+// 超时后中断脚本
+func watch(vm *otto.Otto, done chan struct{}, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		vm.Interrupt <- func() { panic(errTimeout) }
+	}
+}
+
+// 以JSON传入全局变量,脚本中得到的是普通的JS对象
+func setGlobals(vm *otto.Otto, globals map[string]interface{}) error {
+	for name, val := range globals {
+		data, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		obj, err := vm.Object("(" + string(data) + ")")
+		if err != nil {
+			return err
+		}
+		if err := vm.Set(name, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
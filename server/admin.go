@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/kaixinhupo/apiagent/captcha"
+	http2 "github.com/kaixinhupo/apiagent/http"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// 人工输入的验证码答案
+type CaptchaAnswer struct {
+	Id     string
+	Answer string
+}
+
+// 管理接口
+// GET /admin/captcha 等待人工输入的验证码列表
+// GET /admin/captcha/image?id= 验证码图片
+// POST /admin/captcha/answer 提交验证码答案
+// GET /admin/accounts 账号池中各账号的健康状态
+// GET /admin/usage 各调用方的请求计数和配额
+// GET /admin/schedules 定时任务的状态
+// GET /admin/sessions 调用方会话列表
+// DELETE /admin/sessions?owner=&site=&handle= 删除调用方会话
+func (h *HttpHandler) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/admin/schedules" && r.Method == http.MethodGet:
+		writeJson(w, h.jobs.List())
+	case r.URL.Path == "/admin/usage" && r.Method == http.MethodGet:
+		writeJson(w, h.quota.Usages())
+	case r.URL.Path == "/admin/sessions" && r.Method == http.MethodGet:
+		writeJson(w, http2.DefaultSessions().List())
+	case r.URL.Path == "/admin/sessions" && r.Method == http.MethodDelete:
+		if !http2.DefaultSessions().Remove(r.URL.Query().Get("owner"), r.URL.Query().Get("site"), r.URL.Query().Get("handle")) {
+			writeResponse(w, http.StatusNotFound, "会话不存在")
+			return
+		}
+		writeResponse(w, http.StatusOK, "")
+	case r.URL.Path == "/admin/accounts" && r.Method == http.MethodGet:
+		pools, err := http2.DefaultPools()
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJson(w, pools.Health())
+	case r.URL.Path == "/admin/captcha" && r.Method == http.MethodGet:
+		writeJson(w, captcha.PendingList())
+	case r.URL.Path == "/admin/captcha/image" && r.Method == http.MethodGet:
+		image, contentType, ok := captcha.Image(r.URL.Query().Get("id"))
+		if !ok {
+			writeResponse(w, http.StatusNotFound, "验证码不存在")
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(image)
+	case r.URL.Path == "/admin/captcha/answer" && r.Method == http.MethodPost:
+		data, err := ioutil.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, "")
+			return
+		}
+		answer := &CaptchaAnswer{}
+		if err := json.Unmarshal(data, answer); err != nil || answer.Id == "" {
+			writeResponse(w, http.StatusBadRequest, "")
+			return
+		}
+		if !captcha.Answer(answer.Id, answer.Answer) {
+			writeResponse(w, http.StatusNotFound, "验证码不存在或已回答")
+			return
+		}
+		writeResponse(w, http.StatusOK, "")
+	default:
+		writeResponse(w, http.StatusNotFound, "")
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("序列化结果数据时发生错误：", err)
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, http.StatusOK, string(data))
+}
@@ -1,10 +1,17 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"github.com/kaixinhupo/apiagent/auth"
+	"github.com/kaixinhupo/apiagent/cache"
 	"github.com/kaixinhupo/apiagent/config"
+	errors2 "github.com/kaixinhupo/apiagent/errors"
 	http2 "github.com/kaixinhupo/apiagent/http"
-	"github.com/kaixinhupo/apiagent/util"
+	"github.com/kaixinhupo/apiagent/job"
+	"github.com/kaixinhupo/apiagent/quota"
+	"github.com/kaixinhupo/apiagent/schedule"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -12,11 +19,53 @@ import (
 )
 
 type HttpHandler struct {
+	auth  auth.Chain
+	quota *quota.Limiter
+	cache *cache.Cache
+	jobs  *schedule.Scheduler
+	async *job.Manager
+}
+
+// 按配置创建处理器,未配置认证方式时拒绝所有调用
+func NewHttpHandler(cfg *config.Config, jobs *schedule.Scheduler, async *job.Manager) (*HttpHandler, error) {
+	chain, err := auth.New(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		log.Println("未配置认证方式,所有调用都将被拒绝")
+	}
+	resultCache, err := cache.New(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return &HttpHandler{auth: chain, quota: quota.NewLimiter(cfg.Clients), cache: resultCache, jobs: jobs, async: async}, nil
 }
 
 type Message struct {
-	Task string
-	Data map[string]string
+	Task        string
+	Data        map[string]interface{}
+	Session     string            //调用方会话标识,由登录后响应的X-Session返回,使用之前登录的会话
+	Credentials map[string]string //调用方的上游账号参数,会话无效时使用其登录并保存会话
+	Callback    string            //异步任务结束后通知的地址,仅用于/jobs,必须是调用方配置的Callbacks之一
+}
+
+const (
+	SessionHeader     = "X-Session"      //返回调用方会话标识的响应头
+	CacheHeader       = "X-Cache"        //返回缓存状态的响应头
+	CacheBypassHeader = "X-Cache-Bypass" //调用方要求不读取缓存的请求头
+)
+
+// 异步任务提交成功时返回的任务标识
+type JobAccepted struct {
+	Id     string
+	Status string
+}
+
+// 结果校验不通过时返回给调用方的错误码和信息
+type CheckFailure struct {
+	Code    string
+	Message string
 }
 
 func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -26,61 +75,225 @@ func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		writeResponse(w, http.StatusOK, "Welcome")
 	} else if path == "/favicon.ico" {
 		writeResponse(w, http.StatusNotFound, "")
-	} else if path == "call" && strings.ToLower(r.Method) == "post" {
-		cfg, _ := config.DefaultConfig()
-		if verify(r, cfg) {
-			data, err := ioutil.ReadAll(r.Body)
-			_ = r.Body.Close()
-			if err != nil {
-				log.Println("读取请求数据发生错误")
-				writeResponse(w, http.StatusInternalServerError, "")
-				return
-			}
-			msg := &Message{}
-			err = json.Unmarshal(data, msg)
-			if err != nil {
-				log.Println("解析请求数据发生错误")
-				writeResponse(w, http.StatusBadRequest, "")
-				return
+	} else if path == "/call" && strings.ToLower(r.Method) == "post" {
+		identity, msg, task, ok := h.accept(w, r)
+		if !ok {
+			return
+		}
+		var rst map[string]interface{}
+		var err error
+		if msg.Session != "" || len(msg.Credentials) > 0 {
+			var handle string
+			rst, handle, err = http2.DefaultSessions().RunTask(identity.Client, msg.Session, msg.Credentials, task, msg.Data)
+			if handle != "" {
+				w.Header().Set(SessionHeader, handle)
 			}
-			task := cfg.GetTaskByName(msg.Task)
-			if task == nil {
-				writeResponse(w, http.StatusBadRequest, "任务未定义")
-				return
+		} else if task.Cache != nil && task.Cache.Ttl > 0 {
+			var status string
+			rst, status, err = h.cache.Get(cache.Key(task, msg.Data), task.Cache, bypassCache(r), func() (map[string]interface{}, error) {
+				return http2.RunTask(task, msg.Data)
+			})
+			if status != "" {
+				w.Header().Set(CacheHeader, status)
 			}
-			rst, err := http2.DefaultClient().RunTask(task)
-			if err != nil {
-				log.Println("执行任务时发生错误：", err)
-				writeResponse(w, http.StatusInternalServerError, err.Error())
+		} else {
+			rst, err = http2.RunTask(task, msg.Data)
+		}
+		if err != nil {
+			h.quota.Fail(identity.Client)
+		}
+		if err == http2.ErrNoAccount {
+			writeResponse(w, http.StatusServiceUnavailable, err.Error())
+		} else if err == http2.ErrSessionExpired || errors.Is(err, http2.ErrLoginFailed) {
+			writeResponse(w, http.StatusUnauthorized, err.Error())
+		} else if chkErr := checkError(err); chkErr != nil {
+			log.Println("结果校验不通过：", err)
+			body, _ := json.Marshal(&CheckFailure{Code: chkErr.Code, Message: chkErr.Error()})
+			writeResponse(w, http.StatusUnprocessableEntity, string(body))
+		} else if err != nil {
+			log.Println("执行任务时发生错误：", err)
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+		} else {
+			rstJson, err := json.Marshal(rst)
+			if err == nil {
+				writeResponse(w, http.StatusOK, string(rstJson))
 			} else {
-				rstJson, err := json.Marshal(rst)
-				if err == nil {
-					writeResponse(w, http.StatusOK, string(rstJson))
-				} else {
-					log.Println("序列化结果数据时发生错误：", err)
-					writeResponse(w, http.StatusInternalServerError, err.Error())
-				}
+				log.Println("序列化结果数据时发生错误：", err)
+				writeResponse(w, http.StatusInternalServerError, err.Error())
 			}
-		} else {
+		}
+	} else if path == "/jobs" && r.Method == http.MethodPost {
+		identity, msg, task, ok := h.accept(w, r)
+		if !ok {
+			return
+		}
+		if msg.Session != "" || len(msg.Credentials) > 0 {
+			writeResponse(w, http.StatusBadRequest, "异步任务不支持调用方会话")
+			return
+		}
+		j, err := h.async.Submit(identity.Client, task, msg.Data, msg.Callback)
+		if err == job.ErrCallbackDenied {
+			writeResponse(w, http.StatusBadRequest, err.Error())
+			return
+		} else if err != nil {
+			writeResponse(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		body, _ := json.Marshal(&JobAccepted{Id: j.Id, Status: j.Status})
+		writeResponse(w, http.StatusAccepted, string(body))
+	} else if path == "/jobs" && (r.Method == http.MethodGet || r.Method == http.MethodDelete) {
+		identity, _, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		id := r.URL.Query().Get("id")
+		j, ok := h.async.Get(id)
+		// 只有提交任务的调用方和管理员可以查看或取消任务
+		if !ok || (j.Client != identity.Client && !identity.IsAdmin()) {
+			writeResponse(w, http.StatusNotFound, "任务不存在")
+			return
+		}
+		if r.Method == http.MethodGet {
+			writeJson(w, j)
+			return
+		}
+		if err := h.async.Cancel(id); err != nil {
+			writeResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeResponse(w, http.StatusOK, "")
+	} else if path == "/schedule" && r.Method == http.MethodGet {
+		identity, _, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		name := r.URL.Query().Get("name")
+		task, ok := h.jobs.Task(name)
+		if !ok {
+			writeResponse(w, http.StatusNotFound, "定时任务不存在")
+			return
+		}
+		if !h.authorize(identity, task) {
+			writeResponse(w, http.StatusForbidden, "无权查看该任务")
+			return
+		}
+		runs, _ := h.jobs.Runs(name)
+		writeJson(w, runs)
+	} else if strings.HasPrefix(path, "/admin/") {
+		identity, _, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if !identity.IsAdmin() {
 			writeResponse(w, http.StatusForbidden, "")
+			return
 		}
+		h.serveAdmin(w, r)
 	} else {
 		writeResponse(w, http.StatusNotFound, "")
 	}
 }
 
-func verify(request *http.Request, cfg *config.Config) bool {
-	token := request.Header.Get("x-token")
-	if token == "" {
+// 认证调用方,解析请求并检查权限和配额,失败时写入响应并返回false
+func (h *HttpHandler) accept(w http.ResponseWriter, r *http.Request) (*auth.Identity, *Message, *config.Task, bool) {
+	identity, data, ok := h.authenticate(w, r)
+	if !ok {
+		return nil, nil, nil, false
+	}
+	msg := &Message{}
+	err := json.Unmarshal(data, msg)
+	if err != nil {
+		log.Println("解析请求数据发生错误")
+		writeResponse(w, http.StatusBadRequest, "")
+		return nil, nil, nil, false
+	}
+	cfg, _ := config.DefaultConfig()
+	task := cfg.GetTaskByName(msg.Task)
+	if task == nil {
+		writeResponse(w, http.StatusBadRequest, "任务未定义")
+		return nil, nil, nil, false
+	}
+	if !h.authorize(identity, task) {
+		h.quota.Deny(identity.Client)
+		writeResponse(w, http.StatusForbidden, "无权调用该任务")
+		return nil, nil, nil, false
+	}
+	msg.Data = cfg.CallerInputs(task, msg.Data)
+	if !h.quota.Allow(identity.Client) {
+		writeResponse(w, http.StatusTooManyRequests, "超过调用配额")
+		return nil, nil, nil, false
+	}
+	return identity, msg, task, true
+}
+
+// 认证方式的Scopes和调用方配置的Tasks都需要允许该任务,登录任务必须被明确列出,不能通过通配符调用
+func (h *HttpHandler) authorize(identity *auth.Identity, task *config.Task) bool {
+	name := task.FullName()
+	if !identity.Allows(name) {
 		return false
 	}
-	data := util.AesDecrypt([]byte(token), []byte(cfg.AesKey))
-	return string(data) == cfg.CheckData
+	policy := h.quota.Policy(identity.Client)
+	if policy != nil && len(policy.Tasks) > 0 && !auth.Match(policy.Tasks, name) {
+		return false
+	}
+	if task == task.Site().GetLoginTask() {
+		return auth.MatchExact(identity.Scopes, name) || (policy != nil && auth.MatchExact(policy.Tasks, name))
+	}
+	return true
+}
+
+// 调用方通过X-Cache-Bypass或Cache-Control: no-cache要求不读取缓存
+func bypassCache(r *http.Request) bool {
+	switch strings.ToLower(r.Header.Get(CacheBypassHeader)) {
+	case "1", "true":
+		return true
+	}
+	return strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+}
+
+// 读取请求体并认证调用方,失败时写入响应并返回false
+// 请求体参与HMAC签名,读取后重新放回请求中
+func (h *HttpHandler) authenticate(w http.ResponseWriter, r *http.Request) (*auth.Identity, []byte, bool) {
+	data, err := ioutil.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if err != nil {
+		log.Println("读取请求数据发生错误")
+		writeResponse(w, http.StatusInternalServerError, "")
+		return nil, nil, false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	identity, err := h.auth.Authenticate(r, data)
+	if err != nil {
+		log.Println("认证失败：", err)
+		writeResponse(w, http.StatusUnauthorized, "")
+		return nil, nil, false
+	}
+	return identity, data, true
+}
+
+// 在错误链和并行组错误中查找校验错误
+func checkError(err error) *errors2.CheckError {
+	if err == nil {
+		return nil
+	}
+	var chkErr *errors2.CheckError
+	if errors.As(err, &chkErr) {
+		return chkErr
+	}
+	var groupErr *errors2.GroupError
+	if errors.As(err, &groupErr) {
+		for _, e := range groupErr.Errors {
+			if chkErr := checkError(e); chkErr != nil {
+				return chkErr
+			}
+		}
+	}
+	return nil
 }
 
 func writeResponse(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(status)
-	w.Header().Add("Content-Type", "plain/text")
 	if len(body) > 0 {
 		_, _ = w.Write([]byte(body))
 	}
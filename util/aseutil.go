@@ -1,22 +0,0 @@
-package util
-
-import (
-	"crypto/aes"
-	"crypto/cipher"
-)
-
-func AesDecrypt(encrypted, key []byte) []byte {
-	block, _ := aes.NewCipher(key)
-	blockSize := block.BlockSize()
-	blockMode := cipher.NewCBCDecrypter(block, key[:blockSize])
-	origData := make([]byte, len(encrypted))
-	blockMode.CryptBlocks(origData, encrypted)
-	origData = PKCS7UnPadding(origData)
-	return origData
-}
-
-func PKCS7UnPadding(origData []byte) []byte {
-	length := len(origData)
-	unpadding := int(origData[length-1])
-	return origData[:length-unpadding]
-}
@@ -0,0 +1,198 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 步骤上下文,保存字符串、数字、对象和数组,支持以点分隔的路径访问,如 list.0.Title
+type Context struct {
+	data    map[string]interface{}
+	control *control
+}
+
+// 任务执行的取消信号和进度报告,复制上下文时共享
+type control struct {
+	ctx      context.Context
+	progress func(event string)
+}
+
+func NewContext() *Context {
+	return &Context{data: make(map[string]interface{})}
+}
+
+// 按路径取值,优先匹配完整的键
+func (c *Context) Get(path string) (interface{}, bool) {
+	if v, ok := c.data[path]; ok {
+		return v, true
+	}
+	if !strings.Contains(path, ".") {
+		return nil, false
+	}
+	return Lookup(c.data, strings.Split(path, "."))
+}
+
+// 按路径取值并转换为字符串
+func (c *Context) GetString(path string) (string, bool) {
+	v, ok := c.Get(path)
+	if !ok {
+		return "", false
+	}
+	return ToString(v), true
+}
+
+func (c *Context) Set(key string, value interface{}) {
+	c.data[key] = value
+}
+
+// 按路径设置值,中间不存在的对象自动创建
+func (c *Context) SetPath(path string, value interface{}) {
+	SetPath(c.data, path, value)
+}
+
+func (c *Context) Delete(key string) {
+	delete(c.data, key)
+}
+
+// 复制上下文,只复制第一层
+func (c *Context) Copy() *Context {
+	data := make(map[string]interface{}, len(c.data))
+	CopyMap(c.data, data)
+	return &Context{data: data, control: c.control}
+}
+
+// 深复制上下文,并发执行的步骤修改各自的副本,取消信号可以单独取消
+func (c *Context) Fork() (*Context, context.CancelFunc) {
+	data := DeepCopy(c.data).(map[string]interface{})
+	ctx, cancel := context.WithCancel(c.Done())
+	fork := &Context{data: data}
+	if c.control != nil {
+		fork.control = &control{ctx: ctx, progress: c.control.progress}
+	} else {
+		fork.control = &control{ctx: ctx}
+	}
+	return fork, cancel
+}
+
+// 设置取消信号和进度报告,progress可以为nil
+func (c *Context) SetControl(ctx context.Context, progress func(event string)) {
+	c.control = &control{ctx: ctx, progress: progress}
+}
+
+// 取消信号,未设置时返回context.Background()
+func (c *Context) Done() context.Context {
+	if c.control == nil || c.control.ctx == nil {
+		return context.Background()
+	}
+	return c.control.ctx
+}
+
+// 任务被取消时返回错误
+func (c *Context) Err() error {
+	return c.Done().Err()
+}
+
+// 报告执行进度
+func (c *Context) Report(event string) {
+	if c.control != nil && c.control.progress != nil {
+		c.control.progress(event)
+	}
+}
+
+// 上下文中的全部数据,用于模板渲染
+func (c *Context) Data() map[string]interface{} {
+	return c.data
+}
+
+// 在嵌套的对象和数组中按路径取值
+func Lookup(value interface{}, parts []string) (interface{}, bool) {
+	current := value
+	for _, part := range parts {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			v, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case map[string]string:
+			v, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []map[string]interface{}:
+			i, ok := index(part, len(node))
+			if !ok {
+				return nil, false
+			}
+			current = node[i]
+		case []interface{}:
+			i, ok := index(part, len(node))
+			if !ok {
+				return nil, false
+			}
+			current = node[i]
+		case []string:
+			i, ok := index(part, len(node))
+			if !ok {
+				return nil, false
+			}
+			current = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// 按路径设置值,中间不存在或不是对象的节点替换为新对象
+func SetPath(data map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := data
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+func index(part string, length int) (int, bool) {
+	i, err := strconv.Atoi(part)
+	if err != nil || i < 0 || i >= length {
+		return 0, false
+	}
+	return i, true
+}
+
+// 转换为字符串,对象和数组转换为JSON
+func ToString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case fmt.Stringer:
+		return val.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -5,3 +5,29 @@ func CopyMap(src map[string]interface{}, target map[string]interface{}) {
 		target[k] = v
 	}
 }
+
+// 深复制对象和数组,其他值直接引用
+func DeepCopy(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		target := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			target[k] = DeepCopy(item)
+		}
+		return target
+	case []map[string]interface{}:
+		target := make([]map[string]interface{}, len(val))
+		for i, item := range val {
+			target[i] = DeepCopy(item).(map[string]interface{})
+		}
+		return target
+	case []interface{}:
+		target := make([]interface{}, len(val))
+		for i, item := range val {
+			target[i] = DeepCopy(item)
+		}
+		return target
+	default:
+		return v
+	}
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+const (
+	TimestampHeader = "X-Timestamp"
+	SignatureHeader = "X-Signature"
+
+	defaultRetries        = 3
+	defaultWebhookTimeout = 10 * time.Second
+	retryInterval         = time.Second
+)
+
+// 将v序列化为JSON,在后台发送到所有地址
+func Notify(webhooks []*cfg.Webhook, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Println("序列化通知内容发生错误:", err)
+		return
+	}
+	for _, hook := range webhooks {
+		go Deliver(hook, body)
+	}
+}
+
+// 发送通知,失败时按1秒、2秒、4秒……的间隔重试
+func Deliver(hook *cfg.Webhook, body []byte) {
+	retries := defaultRetries
+	if hook.Retries != nil && *hook.Retries >= 0 {
+		retries = *hook.Retries
+	}
+	timeout := defaultWebhookTimeout
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	wait := retryInterval
+	for attempt := 0; ; attempt++ {
+		err := post(client, hook, body)
+		if err == nil {
+			return
+		}
+		if attempt >= retries {
+			log.Println("发送通知失败:", hook.Url, err)
+			return
+		}
+		log.Println("发送通知失败,稍后重试:", hook.Url, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func post(client *http.Client, hook *cfg.Webhook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	if hook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("返回状态码%d", resp.StatusCode)
+	}
+	return nil
+}
+
+// 计算通知的签名
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	cfg "github.com/kaixinhupo/apiagent/config"
+)
+
+func TestDeliverRetries(t *testing.T) {
+	zero, one := 0, 1
+	tests := []struct {
+		name     string
+		retries  *int
+		failures int32
+		attempts int32
+	}{
+		{"success", nil, 0, 1},
+		{"no retries", &zero, 5, 1},
+		{"one retry", &one, 5, 2},
+		{"retry succeeds", nil, 1, 2},
+	}
+	for _, test := range tests {
+		var attempts int32
+		failures := test.failures
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) <= failures {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+		Deliver(&cfg.Webhook{Url: srv.URL, Retries: test.retries}, []byte("{}"))
+		srv.Close()
+		if attempts != test.attempts {
+			t.Errorf("%s: attempts = %d, want %d", test.name, attempts, test.attempts)
+		}
+	}
+}
+
+func TestDeliverSignature(t *testing.T) {
+	body := []byte(`{"Added":[]}`)
+	var ok int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) == Sign("secret", r.Header.Get(TimestampHeader), data) {
+			atomic.StoreInt32(&ok, 1)
+		}
+	}))
+	defer srv.Close()
+	Deliver(&cfg.Webhook{Url: srv.URL, Secret: "secret"}, body)
+	if ok != 1 {
+		t.Error("signature not verified")
+	}
+}